@@ -0,0 +1,103 @@
+// Package barcode validates and normalizes GS1 product-codes (UPC-A, EAN-8,
+// EAN-13 and GTIN-14), which are all represented canonically as GTIN-14.
+package barcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GTIN14Length is the length of canonical GTIN-14 codes.
+const GTIN14Length = 14
+
+// Lengths of supported product-codes.
+const (
+	EAN8Length  = 8
+	UPCALength  = 12
+	EAN13Length = 13
+)
+
+// ErrInvalidLength is returned when code's length does not match
+// any supported format.
+var ErrInvalidLength = errors.New(
+	"length must be 8 (EAN-8), 12 (UPC-A), 13 (EAN-13) or 14 (GTIN-14)",
+)
+
+// ErrNonNumeric is returned when code contains non-digit characters.
+var ErrNonNumeric = errors.New("code must only contain digits")
+
+// ErrInvalidCheckDigit is returned when code's check-digit does not
+// match its contents.
+var ErrInvalidCheckDigit = errors.New("invalid check-digit")
+
+// CheckDigit calculates the GS1 check-digit for the provided digits,
+// which must not include the check-digit itself.
+func CheckDigit(digits string) (int, error) {
+	sum := 0
+	// Weights alternate 3, 1, 3... starting from the rightmost digit
+	weight := 3
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if d < '0' || d > '9' {
+			return 0, ErrNonNumeric
+		}
+		sum += int(d-'0') * weight
+		weight = 4 - weight
+	}
+	return (10 - sum%10) % 10, nil
+}
+
+// Validate checks that the code is a valid UPC-A, EAN-8, EAN-13 or GTIN-14.
+func Validate(code string) error {
+	switch len(code) {
+	case EAN8Length, UPCALength, EAN13Length, GTIN14Length:
+	default:
+		return ErrInvalidLength
+	}
+
+	last := len(code) - 1
+	check, err := CheckDigit(code[:last])
+	if err != nil {
+		return err
+	}
+	if code[last] < '0' || code[last] > '9' {
+		return ErrNonNumeric
+	}
+	if int(code[last]-'0') != check {
+		return ErrInvalidCheckDigit
+	}
+	return nil
+}
+
+// Normalize validates the code and returns it as GTIN-14.
+// Surrounding whitespace is ignored.
+func Normalize(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	err := Validate(code)
+	if err != nil {
+		return "", err
+	}
+	return strings.Repeat("0", GTIN14Length-len(code)) + code, nil
+}
+
+// FromInt converts a numeric code, such as a UPC whose leading zeros were lost,
+// to GTIN-14.
+func FromInt(code int64) (string, error) {
+	if code <= 0 {
+		return "", ErrInvalidLength
+	}
+	str := strconv.FormatInt(code, 10)
+	if len(str) > GTIN14Length {
+		return "", ErrInvalidLength
+	}
+
+	gtin := fmt.Sprintf("%014d", code)
+	err := Validate(gtin)
+	if err != nil {
+		return "", err
+	}
+	return gtin, nil
+}
@@ -0,0 +1,147 @@
+package barcode
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBarcode(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Barcode Suite")
+}
+
+var _ = Describe("Barcode", func() {
+	Describe("CheckDigit", func() {
+		It("should calculate check-digit", func() {
+			check, err := CheckDigit("03600029145")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(check).To(Equal(2))
+
+			check, err = CheckDigit("400638133393")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(check).To(Equal(1))
+		})
+
+		It("should return error if digits are non-numeric", func() {
+			_, err := CheckDigit("0360002914a")
+			Expect(err).To(Equal(ErrNonNumeric))
+		})
+	})
+
+	Describe("Normalize", func() {
+		It("should normalize supported formats to GTIN-14", func() {
+			codes := map[string]string{
+				"96385074":       "00000096385074",
+				"036000291452":   "00036000291452",
+				"4006381333931":  "04006381333931",
+				"10012345678902": "10012345678902",
+			}
+			for code, gtin := range codes {
+				n, err := Normalize(code)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(gtin))
+			}
+		})
+
+		It("should return error if check-digit is invalid", func() {
+			_, err := Normalize("036000291453")
+			Expect(err).To(Equal(ErrInvalidCheckDigit))
+		})
+
+		It("should return error if length is invalid", func() {
+			_, err := Normalize("0360002914")
+			Expect(err).To(Equal(ErrInvalidLength))
+		})
+
+		It("should return error if code is non-numeric", func() {
+			_, err := Normalize("03600029145x")
+			Expect(err).To(Equal(ErrNonNumeric))
+		})
+	})
+
+	Describe("FromInt", func() {
+		It("should restore leading zeros", func() {
+			gtin, err := FromInt(36000291452)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gtin).To(Equal("00036000291452"))
+		})
+
+		It("should return error if check-digit is invalid", func() {
+			_, err := FromInt(36000291453)
+			Expect(err).To(Equal(ErrInvalidCheckDigit))
+		})
+	})
+
+	Describe("ParseGS1", func() {
+		It("should parse raw element strings", func() {
+			elementStr := "]C1" + "0109506000134352" + "17201225" +
+				"10ABC123" + string(GroupSeparator) + "3103001250"
+			gs1, err := ParseGS1(elementStr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gs1.GTIN).To(Equal("09506000134352"))
+			Expect(gs1.Expiry).To(Equal(time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC)))
+			Expect(gs1.Lot).To(Equal("ABC123"))
+			Expect(gs1.NetWeight).To(BeNumerically("~", 1.25))
+		})
+
+		It("should parse bracketed element strings", func() {
+			gs1, err := ParseGS1("(01)09506000134352(10)ABC123(17)201200")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gs1.GTIN).To(Equal("09506000134352"))
+			Expect(gs1.Expiry).To(Equal(time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)))
+			Expect(gs1.Lot).To(Equal("ABC123"))
+		})
+
+		It("should return error if GTIN is invalid", func() {
+			_, err := ParseGS1("0109506000134353")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if AI is unsupported", func() {
+			_, err := ParseGS1("9912345")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if fixed-length data is incomplete", func() {
+			_, err := ParseGS1("0109506000134352172012")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if date is invalid", func() {
+			_, err := ParseGS1("(17)201232")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DigitalLink", func() {
+		It("should format Digital Link URIs", func() {
+			uri, err := FormatDigitalLink("https://id.example/", &GS1{
+				GTIN:   "9506000134352",
+				Lot:    "ABC 123",
+				Expiry: time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(uri).To(Equal(
+				"https://id.example/01/09506000134352/10/ABC%20123?17=201225",
+			))
+		})
+
+		It("should parse Digital Link URIs", func() {
+			gs1, err := ParseDigitalLink(
+				"https://example.com/products/01/9506000134352/10/ABC%20123?17=201225",
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gs1.GTIN).To(Equal("09506000134352"))
+			Expect(gs1.Lot).To(Equal("ABC 123"))
+			Expect(gs1.Expiry).To(Equal(time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should return error if GTIN is missing", func() {
+			_, err := ParseDigitalLink("https://id.example/10/ABC123")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
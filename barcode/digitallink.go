@@ -0,0 +1,84 @@
+package barcode
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FormatDigitalLink creates the GS1 Digital Link URI for the GTIN, lot and
+// expiry, in the form: {domain}/01/{gtin}/10/{lot}?17={expiry}.
+// The lot and expiry are only included if specified.
+func FormatDigitalLink(domain string, gs1 *GS1) (string, error) {
+	if gs1.GTIN == "" {
+		return "", errors.New("GTIN is required for Digital Link")
+	}
+	gtin, err := Normalize(gs1.GTIN)
+	if err != nil {
+		return "", err
+	}
+
+	uri := strings.TrimRight(domain, "/") + "/" + AIGTIN + "/" + gtin
+	if gs1.Lot != "" {
+		uri += "/" + AILot + "/" + url.PathEscape(gs1.Lot)
+	}
+	if !gs1.Expiry.IsZero() {
+		query := url.Values{}
+		query.Set(AIExpiry, FormatDate(gs1.Expiry))
+		uri += "?" + query.Encode()
+	}
+	return uri, nil
+}
+
+// ParseDigitalLink parses the shipment-related data from GS1 Digital Link URI.
+// Any domain and path-prefix before the GTIN are accepted. Data-attributes,
+// such as expiry, are read from query-parameters keyed by their AIs.
+func ParseDigitalLink(uri string) (*GS1, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		err = errors.Wrap(err, "Error parsing Digital Link")
+		return nil, err
+	}
+
+	segments := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	start := -1
+	for i, segment := range segments {
+		if segment == AIGTIN {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return nil, errors.New("Digital Link must contain GTIN (01)")
+	}
+	segments = segments[start:]
+	if len(segments)%2 != 0 {
+		return nil, errors.New("Digital Link path must contain AI and value pairs")
+	}
+
+	elements := map[string]string{}
+	for i := 0; i < len(segments); i += 2 {
+		ai := segments[i]
+		value, err := url.PathUnescape(segments[i+1])
+		if err != nil {
+			err = errors.Wrapf(err, "AI (%s)", ai)
+			return nil, err
+		}
+		if ai == AIGTIN {
+			value, err = Normalize(value)
+			if err != nil {
+				err = errors.Wrapf(err, "AI (%s)", ai)
+				return nil, err
+			}
+		}
+		elements[ai] = value
+	}
+	for ai, values := range u.Query() {
+		if len(values) > 0 {
+			elements[ai] = values[0]
+		}
+	}
+
+	return fromElements(elements)
+}
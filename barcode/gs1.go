@@ -0,0 +1,257 @@
+package barcode
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GroupSeparator is the FNC1 character which terminates variable-length
+// elements in GS1 element strings.
+const GroupSeparator = '\x1d'
+
+// Application Identifiers used for shipments.
+const (
+	AIGTIN      = "01"
+	AILot       = "10"
+	AIExpiry    = "17"
+	AINetWeight = "310"
+)
+
+// aiSpec describes the format of an Application Identifier.
+type aiSpec struct {
+	// aiLength is the number of digits in the AI itself.
+	aiLength int
+	// dataLength is the exact length of fixed-length data,
+	// or the maximum length of variable-length data.
+	dataLength int
+	variable   bool
+}
+
+// aiSpecs are the supported Application Identifiers, keyed by
+// their first two digits.
+var aiSpecs = map[string]aiSpec{
+	"00": aiSpec{2, 18, false},
+	"01": aiSpec{2, 14, false},
+	"02": aiSpec{2, 14, false},
+	"10": aiSpec{2, 20, true},
+	"11": aiSpec{2, 6, false},
+	"12": aiSpec{2, 6, false},
+	"13": aiSpec{2, 6, false},
+	"15": aiSpec{2, 6, false},
+	"16": aiSpec{2, 6, false},
+	"17": aiSpec{2, 6, false},
+	"20": aiSpec{2, 2, false},
+	"21": aiSpec{2, 20, true},
+	"31": aiSpec{4, 6, false},
+	"32": aiSpec{4, 6, false},
+	"33": aiSpec{4, 6, false},
+	"34": aiSpec{4, 6, false},
+	"35": aiSpec{4, 6, false},
+	"36": aiSpec{4, 6, false},
+}
+
+// symbologyIDs are the prefixes added by scanners to identify GS1-128,
+// GS1 DataMatrix and GS1 QR Code symbols.
+var symbologyIDs = []string{"]C1", "]d2", "]Q3", "]e0"}
+
+var bracketedElement = regexp.MustCompile(`\((\d{2,4})\)([^(]*)`)
+
+// GS1 is the shipment-related data from a GS1 element string.
+type GS1 struct {
+	// GTIN is the GTIN-14 from AI (01).
+	GTIN string
+	// Lot is the batch or lot number from AI (10).
+	Lot string
+	// Expiry is the expiration date from AI (17).
+	Expiry time.Time
+	// NetWeight is the net weight in kilograms from AI (310n).
+	NetWeight float64
+}
+
+// ParseElements parses the GS1 element string into its elements, keyed by
+// Application Identifier. Both the raw form, as captured by scanners, and the
+// human-readable form with bracketed AIs are supported.
+func ParseElements(str string) (map[string]string, error) {
+	for _, id := range symbologyIDs {
+		str = strings.TrimPrefix(str, id)
+	}
+	str = strings.TrimLeft(str, string(GroupSeparator))
+	if str == "" {
+		return nil, errors.New("blank element string")
+	}
+	if strings.HasPrefix(str, "(") {
+		return parseBracketed(str)
+	}
+
+	elements := map[string]string{}
+	for len(str) > 0 {
+		if len(str) < 2 {
+			return nil, errors.Errorf("incomplete AI: %s", str)
+		}
+		spec, exists := aiSpecs[str[:2]]
+		if !exists {
+			return nil, errors.Errorf("unsupported AI: %s", str[:2])
+		}
+		if len(str) < spec.aiLength {
+			return nil, errors.Errorf("incomplete AI: %s", str)
+		}
+		ai := str[:spec.aiLength]
+		if strings.Trim(ai, "0123456789") != "" {
+			return nil, errors.Errorf("unsupported AI: %s", ai)
+		}
+		str = str[spec.aiLength:]
+
+		var data string
+		if spec.variable {
+			end := strings.IndexRune(str, GroupSeparator)
+			if end == -1 {
+				end = len(str)
+			}
+			data = str[:end]
+			str = strings.TrimPrefix(str[end:], string(GroupSeparator))
+			if len(data) == 0 || len(data) > spec.dataLength {
+				return nil, errors.Errorf(
+					"AI (%s) data must be 1 to %d characters", ai, spec.dataLength,
+				)
+			}
+		} else {
+			if len(str) < spec.dataLength {
+				return nil, errors.Errorf(
+					"AI (%s) data must be %d characters", ai, spec.dataLength,
+				)
+			}
+			data = str[:spec.dataLength]
+			// Separators are allowed, though not required, after fixed-length data
+			str = strings.TrimPrefix(str[spec.dataLength:], string(GroupSeparator))
+		}
+
+		if _, exists := elements[ai]; exists {
+			return nil, errors.Errorf("duplicate AI: %s", ai)
+		}
+		elements[ai] = data
+	}
+	return elements, nil
+}
+
+func parseBracketed(str string) (map[string]string, error) {
+	matches := bracketedElement.FindAllStringSubmatch(str, -1)
+	matchedLen := 0
+	for _, m := range matches {
+		matchedLen += len(m[0])
+	}
+	if matchedLen != len(str) {
+		return nil, errors.Errorf("malformed element string: %s", str)
+	}
+
+	elements := map[string]string{}
+	for _, m := range matches {
+		ai, data := m[1], m[2]
+		spec, exists := aiSpecs[ai[:2]]
+		if !exists || len(ai) != spec.aiLength {
+			return nil, errors.Errorf("unsupported AI: %s", ai)
+		}
+		if spec.variable && (len(data) == 0 || len(data) > spec.dataLength) {
+			return nil, errors.Errorf(
+				"AI (%s) data must be 1 to %d characters", ai, spec.dataLength,
+			)
+		}
+		if !spec.variable && len(data) != spec.dataLength {
+			return nil, errors.Errorf(
+				"AI (%s) data must be %d characters", ai, spec.dataLength,
+			)
+		}
+		if _, exists := elements[ai]; exists {
+			return nil, errors.Errorf("duplicate AI: %s", ai)
+		}
+		elements[ai] = data
+	}
+	return elements, nil
+}
+
+// ParseGS1 parses the shipment-related data from GS1 element string.
+// Elements other than GTIN, lot, expiry and net weight (kg) are ignored.
+func ParseGS1(str string) (*GS1, error) {
+	elements, err := ParseElements(str)
+	if err != nil {
+		return nil, err
+	}
+	return fromElements(elements)
+}
+
+// fromElements reads the shipment-related data from parsed elements.
+func fromElements(elements map[string]string) (*GS1, error) {
+	gs1 := &GS1{}
+	var err error
+	if gtin, exists := elements[AIGTIN]; exists {
+		err = Validate(gtin)
+		if err != nil {
+			err = errors.Wrapf(err, "AI (%s)", AIGTIN)
+			return nil, err
+		}
+		gs1.GTIN = gtin
+	}
+	if lot, exists := elements[AILot]; exists {
+		gs1.Lot = lot
+	}
+	if expiry, exists := elements[AIExpiry]; exists {
+		gs1.Expiry, err = ParseDate(expiry)
+		if err != nil {
+			err = errors.Wrapf(err, "AI (%s)", AIExpiry)
+			return nil, err
+		}
+	}
+
+	for ai, data := range elements {
+		if !strings.HasPrefix(ai, AINetWeight) || len(ai) != 4 {
+			continue
+		}
+		decimals := int(ai[3] - '0')
+		if decimals < 0 || decimals > 9 {
+			return nil, errors.Errorf("unsupported AI: %s", ai)
+		}
+		weight, err := strconv.ParseInt(data, 10, 64)
+		if err != nil {
+			err = errors.Wrapf(err, "AI (%s)", ai)
+			return nil, err
+		}
+		gs1.NetWeight = float64(weight) / math.Pow10(decimals)
+	}
+	return gs1, nil
+}
+
+// ParseDate parses GS1 dates in YYMMDD format, as UTC. A day of "00" denotes
+// the last day of the month. Years are interpreted as 2000-2099.
+func ParseDate(date string) (time.Time, error) {
+	if len(date) != 6 {
+		return time.Time{}, errors.New("date must be in YYMMDD format")
+	}
+	n, err := strconv.Atoi(date)
+	if err != nil || n < 0 {
+		return time.Time{}, errors.New("date must be in YYMMDD format")
+	}
+
+	year, month, day := 2000+n/10000, time.Month(n/100%100), n%100
+	if month < time.January || month > time.December {
+		return time.Time{}, errors.Errorf("invalid month: %02d", month)
+	}
+	if day == 0 {
+		// Day 0 of next month is last day of this month
+		return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day {
+		return time.Time{}, errors.Errorf("invalid day: %02d", day)
+	}
+	return t, nil
+}
+
+// FormatDate formats the date as GS1 YYMMDD.
+func FormatDate(date time.Time) string {
+	return date.UTC().Format("060102")
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/Shopify/sarama"
 	"github.com/TerrexTech/agg-shipment-cmd/shipment"
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-eventspoll/poll"
 	"github.com/TerrexTech/go-kafkautils/kafka"
+	"github.com/pkg/errors"
 )
 
 func loadKafkaConfig() (*poll.KafkaConfig, error) {
@@ -49,3 +51,26 @@ func loadKafkaConfig() (*poll.KafkaConfig, error) {
 
 	return kc, nil
 }
+
+// loadRelayProducer creates the producer for relaying the Outbox. It is a
+// SyncProducer, so entries are marked as sent only once Kafka acknowledged
+// their messages.
+func loadRelayProducer() (sarama.SyncProducer, error) {
+	kafkaBrokers := *commonutil.ParseHosts(
+		os.Getenv("KAFKA_BROKERS"),
+	)
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Compression = sarama.CompressionNone
+	saramaConfig.Version = sarama.V2_0_0_0
+
+	producer, err := sarama.NewSyncProducer(kafkaBrokers, saramaConfig)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Outbox-Relay producer")
+		return nil, err
+	}
+	return producer, nil
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/TerrexTech/agg-shipment-cmd/shipment"
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-eventspoll/poll"
 	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
 	"github.com/pkg/errors"
 )
 
@@ -37,6 +41,46 @@ func loadMongoConfig() (*poll.MongoConfig, error) {
 	}, nil
 }
 
+func loadOutbox(conn *mongo.ConnectionConfig) (*shipment.Outbox, error) {
+	database := os.Getenv("MONGO_DATABASE")
+	outboxCollection := os.Getenv("MONGO_OUTBOX_COLLECTION")
+
+	sentTTLStr := os.Getenv("OUTBOX_SENT_TTL_S")
+	sentTTL, err := strconv.Atoi(sentTTLStr)
+	if err != nil {
+		err = errors.Wrap(err, "Error converting OUTBOX_SENT_TTL_S to integer")
+		log.Println(err)
+		log.Println("A default value of 604800 will be used for OUTBOX_SENT_TTL_S")
+		sentTTL = 604800
+	}
+
+	batchSizeStr := os.Getenv("OUTBOX_RELAY_BATCH_SIZE")
+	batchSize, err := strconv.Atoi(batchSizeStr)
+	if err != nil {
+		err = errors.Wrap(err, "Error converting OUTBOX_RELAY_BATCH_SIZE to integer")
+		log.Println(err)
+		log.Println("A default value of 100 will be used for OUTBOX_RELAY_BATCH_SIZE")
+		batchSize = 100
+	}
+	if batchSize < 1 {
+		err = errors.Errorf("OUTBOX_RELAY_BATCH_SIZE must be positive, got %d", batchSize)
+		return nil, err
+	}
+
+	outboxMongoCollection, err := createOutboxCollection(
+		conn, database, outboxCollection, int32(sentTTL),
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Outbox MongoCollection")
+		return nil, err
+	}
+
+	return &shipment.Outbox{
+		Collection: outboxMongoCollection,
+		BatchSize:  int64(batchSize),
+	}, nil
+}
+
 func getMongoConn() (*mongo.ConnectionConfig, error) {
 	hosts := *commonutil.ParseHosts(
 		os.Getenv("MONGO_HOSTS"),
@@ -122,3 +166,60 @@ func createMongoCollection(
 	}
 	return collection, nil
 }
+
+// createOutboxCollection creates the collection of outbox entries. Sent
+// entries expire after sentTTL seconds, using a TTL-index on sentAt.
+func createOutboxCollection(
+	conn *mongo.ConnectionConfig, db string, coll string, sentTTL int32,
+) (*mongo.Collection, error) {
+	// Index Configuration
+	indexConfigs := []mongo.IndexConfig{
+		mongo.IndexConfig{
+			ColumnConfig: []mongo.IndexColumnConfig{
+				mongo.IndexColumnConfig{
+					Name: "state",
+				},
+				mongo.IndexColumnConfig{
+					Name: "seq",
+				},
+			},
+			Name: "state_seq_index",
+		},
+	}
+
+	// Create New Collection
+	c := &mongo.Collection{
+		Connection:   conn,
+		Database:     db,
+		Name:         coll,
+		SchemaStruct: &shipment.OutboxEntry{},
+		Indexes:      indexConfigs,
+	}
+	collection, err := mongo.EnsureCollection(c)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating MongoCollection")
+		return nil, err
+	}
+
+	// IndexConfig has no TTL option, so the TTL-index is created directly.
+	// Changing the TTL requires dropping the existing index.
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(conn.Timeout)*time.Millisecond,
+	)
+	defer cancel()
+	_, err = collection.Collection().Indexes().CreateOne(ctx, mgo.IndexModel{
+		Keys: bson.NewDocument(
+			bson.EC.Int32("sentAt", 1),
+		),
+		Options: mgo.NewIndexOptionsBuilder().
+			Name("sentAt_ttl_index").
+			ExpireAfterSeconds(sentTTL).
+			Build(),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating TTL-index for sent entries")
+		return nil, err
+	}
+	return collection, nil
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"log"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/TerrexTech/agg-shipment-cmd/money"
 	"github.com/TerrexTech/agg-shipment-cmd/shipment"
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-eventspoll/poll"
@@ -21,11 +25,13 @@ func validateEnv() error {
 		"KAFKA_CONSUMER_EVENT_QUERY_TOPIC",
 		"KAFKA_PRODUCER_EVENT_QUERY_TOPIC",
 		"KAFKA_PRODUCER_RESPONSE_TOPIC",
+		"KAFKA_PRODUCER_DOMAIN_EVENT_TOPIC",
 
 		"MONGO_HOSTS",
 		"MONGO_DATABASE",
 		"MONGO_AGG_COLLECTION",
 		"MONGO_META_COLLECTION",
+		"MONGO_OUTBOX_COLLECTION",
 
 		"MONGO_CONNECTION_TIMEOUT_MS",
 		"MONGO_RESOURCE_TIMEOUT_MS",
@@ -53,6 +59,72 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	digitalLinkDomain := os.Getenv("GS1_DIGITAL_LINK_DOMAIN")
+	if digitalLinkDomain != "" {
+		shipment.DigitalLinkDomain = digitalLinkDomain
+	}
+	defaultCurrency := os.Getenv("DEFAULT_CURRENCY")
+	if defaultCurrency != "" {
+		err = money.ValidateCurrency(defaultCurrency)
+		if err != nil {
+			err = errors.Wrap(err, "Error in DEFAULT_CURRENCY")
+			log.Fatalln(err)
+		}
+		shipment.DefaultCurrency = defaultCurrency
+	}
+	strictFields := os.Getenv("STRICT_FIELDS")
+	if strictFields != "" {
+		shipment.StrictFields, err = strconv.ParseBool(strictFields)
+		if err != nil {
+			err = errors.Wrap(err, "Error in STRICT_FIELDS")
+			log.Fatalln(err)
+		}
+	}
+	maxFilterDepth := os.Getenv("MAX_FILTER_DEPTH")
+	if maxFilterDepth != "" {
+		shipment.MaxFilterDepth, err = strconv.Atoi(maxFilterDepth)
+		if err != nil {
+			err = errors.Wrap(err, "Error in MAX_FILTER_DEPTH")
+			log.Fatalln(err)
+		}
+		if shipment.MaxFilterDepth < 0 {
+			err = errors.New("MAX_FILTER_DEPTH must not be negative")
+			log.Fatalln(err)
+		}
+	}
+	maxAffected := os.Getenv("MAX_AFFECTED_SHIPMENTS")
+	if maxAffected != "" {
+		shipment.MaxAffected, err = strconv.Atoi(maxAffected)
+		if err != nil {
+			err = errors.Wrap(err, "Error in MAX_AFFECTED_SHIPMENTS")
+			log.Fatalln(err)
+		}
+		if shipment.MaxAffected < 0 {
+			err = errors.New("MAX_AFFECTED_SHIPMENTS must not be negative")
+			log.Fatalln(err)
+		}
+	}
+	maxReturned := os.Getenv("MAX_RETURNED_SHIPMENTS")
+	if maxReturned != "" {
+		shipment.MaxReturned, err = strconv.Atoi(maxReturned)
+		if err != nil {
+			err = errors.Wrap(err, "Error in MAX_RETURNED_SHIPMENTS")
+			log.Fatalln(err)
+		}
+		if shipment.MaxReturned < 0 {
+			err = errors.New("MAX_RETURNED_SHIPMENTS must not be negative")
+			log.Fatalln(err)
+		}
+	}
+	attributeSchema := os.Getenv("ATTRIBUTE_SCHEMA")
+	if attributeSchema != "" {
+		shipment.AttributeSchema, err = shipment.ParseAttributeSchema([]byte(attributeSchema))
+		if err != nil {
+			err = errors.Wrap(err, "Error in ATTRIBUTE_SCHEMA")
+			log.Fatalln(err)
+		}
+	}
+
 	kc, err := loadKafkaConfig()
 	if err != nil {
 		err = errors.Wrap(err, "Error in KafkaConfig")
@@ -63,6 +135,32 @@ func main() {
 		err = errors.Wrap(err, "Error in MongoConfig")
 		log.Fatalln(err)
 	}
+	outbox, err := loadOutbox(mc.Connection)
+	if err != nil {
+		err = errors.Wrap(err, "Error in Outbox")
+		log.Fatalln(err)
+	}
+	// DomainEvents are stored with the KafkaResponses, and relayed together
+	shipment.PublishEvents = outbox.AddEvents
+	relayProducer, err := loadRelayProducer()
+	if err != nil {
+		err = errors.Wrap(err, "Error in Outbox-Relay producer")
+		log.Fatalln(err)
+	}
+	relayInterval := 500 * time.Millisecond
+	relayIntervalStr := os.Getenv("OUTBOX_RELAY_INTERVAL_MS")
+	if relayIntervalStr != "" {
+		relayIntervalMS, err := strconv.Atoi(relayIntervalStr)
+		if err != nil {
+			err = errors.Wrap(err, "Error in OUTBOX_RELAY_INTERVAL_MS")
+			log.Fatalln(err)
+		}
+		if relayIntervalMS < 1 {
+			err = errors.New("OUTBOX_RELAY_INTERVAL_MS must be positive")
+			log.Fatalln(err)
+		}
+		relayInterval = time.Duration(relayIntervalMS) * time.Millisecond
+	}
 	ioConfig := poll.IOConfig{
 		ReadConfig: poll.ReadConfig{
 			EnableInsert: true,
@@ -78,6 +176,14 @@ func main() {
 		err = errors.Wrap(err, "Error creating EventPoll service")
 		log.Fatalln(err)
 	}
+	go relayOutbox(
+		eventPoll.RoutinesCtx(),
+		outbox,
+		relayProducer,
+		os.Getenv("KAFKA_PRODUCER_DOMAIN_EVENT_TOPIC"),
+		os.Getenv("KAFKA_PRODUCER_RESPONSE_TOPIC"),
+		relayInterval,
+	)
 
 	for {
 		select {
@@ -96,7 +202,8 @@ func main() {
 					log.Println(err)
 					return
 				}
-				kafkaResp := shipment.Insert(mc.AggCollection, &eventResp.Event)
+				// Responses are relayed from the Outbox, unless no transaction was committed
+				kafkaResp := outbox.Handle(mc.AggCollection, &eventResp.Event)
 				if kafkaResp != nil {
 					eventPoll.ProduceResult() <- kafkaResp
 				}
@@ -113,7 +220,8 @@ func main() {
 					log.Println(err)
 					return
 				}
-				kafkaResp := shipment.Delete(mc.AggCollection, &eventResp.Event)
+				// Responses are relayed from the Outbox, unless no transaction was committed
+				kafkaResp := outbox.Handle(mc.AggCollection, &eventResp.Event)
 				if kafkaResp != nil {
 					eventPoll.ProduceResult() <- kafkaResp
 				}
@@ -130,7 +238,8 @@ func main() {
 					log.Println(err)
 					return
 				}
-				kafkaResp := shipment.Update(mc.AggCollection, &eventResp.Event)
+				// Responses are relayed from the Outbox, unless no transaction was committed
+				kafkaResp := outbox.Handle(mc.AggCollection, &eventResp.Event)
 				if kafkaResp != nil {
 					eventPoll.ProduceResult() <- kafkaResp
 				}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-shipment-cmd/shipment"
+	"github.com/TerrexTech/go-kafkautils/kafka"
+	"github.com/pkg/errors"
+)
+
+// relayOutbox produces the ready outbox entries at each interval, until the
+// context is closed. DomainEvents are produced to the eventTopic, and
+// KafkaResponses to the responseTopic. Entries are marked as sent only once
+// Kafka acknowledged all their messages, so an entry is produced again if
+// producing it fails, or if the service stops before marking it. Entries are
+// relayed in order, so relaying stops at the first failed entry until the
+// next interval.
+func relayOutbox(
+	ctx context.Context,
+	outbox *shipment.Outbox,
+	producer sarama.SyncProducer,
+	eventTopic string,
+	responseTopic string,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := outbox.Ready()
+		if err != nil {
+			err = errors.Wrap(err, "Error in Outbox-Relay")
+			log.Println(err)
+			continue
+		}
+		for _, entry := range entries {
+			err = relayEntry(producer, entry, eventTopic, responseTopic)
+			if err != nil {
+				err = errors.Wrapf(err, "Error in Outbox-Relay for entry %s", entry.ID)
+				log.Println(err)
+				break
+			}
+			err = outbox.MarkSent(entry)
+			if err != nil {
+				err = errors.Wrapf(err, "Error in Outbox-Relay for entry %s", entry.ID)
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// relayEntry produces the DomainEvents and then the KafkaResponse of the
+// entry, and returns once Kafka acknowledged these.
+func relayEntry(
+	producer sarama.SyncProducer,
+	entry *shipment.OutboxEntry,
+	eventTopic string,
+	responseTopic string,
+) error {
+	_, events, err := entry.Messages()
+	if err != nil {
+		err = errors.Wrap(err, "Error reading entry")
+		return err
+	}
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(events)+1)
+	for _, event := range events {
+		msgs = append(msgs, kafka.CreateMessage(eventTopic, event))
+	}
+	msgs = append(msgs, kafka.CreateMessage(responseTopic, []byte(entry.Response)))
+
+	err = producer.SendMessages(msgs)
+	if err != nil {
+		err = errors.Wrap(err, "Error producing messages")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package money provides an exact decimal representation for monetary amounts,
+// stored as integer minor units (such as cents) along with the currency code.
+package money
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// minorUnits are the number of decimal places for currencies which do not use
+// the default of 2 decimal places, as per ISO 4217.
+var minorUnits = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+// Money is a monetary amount in the minor units of its currency.
+type Money struct {
+	// Amount is the amount in minor units, such as cents.
+	Amount int64
+	// Currency is the ISO 4217 currency code.
+	Currency string
+}
+
+// MinorUnits returns the number of decimal places used by currency.
+func MinorUnits(currency string) int {
+	units, exists := minorUnits[currency]
+	if !exists {
+		return 2
+	}
+	return units
+}
+
+// ValidateCurrency checks if the currency is a 3-letter uppercase code.
+func ValidateCurrency(currency string) error {
+	if len(currency) != 3 || strings.ToUpper(currency) != currency ||
+		strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return errors.Errorf("invalid currency code: %s", currency)
+	}
+	return nil
+}
+
+// FromMinor creates Money from amount in minor units.
+func FromMinor(amount int64, currency string) Money {
+	return Money{
+		Amount:   amount,
+		Currency: currency,
+	}
+}
+
+// Parse creates Money from a decimal string, such as "13.40". The amount
+// cannot have more decimal places than the currency's minor units.
+func Parse(amount string, currency string) (Money, error) {
+	err := ValidateCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor, err := parseMinor(strings.TrimSpace(amount), MinorUnits(currency), false)
+	if err != nil {
+		return Money{}, err
+	}
+	return FromMinor(minor, currency), nil
+}
+
+// FromFloat creates Money from a float amount, such as legacy float prices.
+// The amount is rounded half away from zero to the currency's minor units.
+func FromFloat(amount float64, currency string) (Money, error) {
+	err := ValidateCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	// Shortest representation which round-trips to the same float,
+	// so 13.4 is read as "13.4" instead of 13.4000000000000003552...
+	str := strconv.FormatFloat(amount, 'f', -1, 64)
+	minor, err := parseMinor(str, MinorUnits(currency), true)
+	if err != nil {
+		return Money{}, err
+	}
+	return FromMinor(minor, currency), nil
+}
+
+// parseMinor converts the decimal string to minor units. If round is false,
+// an error is returned when amount has more than the allowed decimal places.
+func parseMinor(amount string, units int, round bool) (int64, error) {
+	neg := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(strings.TrimPrefix(amount, "-"), "+")
+
+	parts := strings.SplitN(amount, ".", 2)
+	whole, frac := parts[0], ""
+	if len(parts) == 2 {
+		frac = parts[1]
+	}
+	if whole == "" && frac == "" {
+		return 0, errors.New("blank amount")
+	}
+	if strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, errors.Errorf("invalid amount: %s", amount)
+	}
+
+	roundUp := false
+	if len(frac) > units {
+		extra := frac[units:]
+		if !round && strings.Trim(extra, "0") != "" {
+			return 0, errors.Errorf(
+				"amount %s has more than %d decimal places", amount, units,
+			)
+		}
+		roundUp = extra[0] >= '5'
+		frac = frac[:units]
+	}
+	frac += strings.Repeat("0", units-len(frac))
+
+	digits := strings.TrimLeft(whole+frac, "0")
+	if digits == "" {
+		digits = "0"
+	}
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		err = errors.Wrapf(err, "amount out of range: %s", amount)
+		return 0, err
+	}
+	if roundUp {
+		minor++
+	}
+	if neg {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+// String formats the amount as a decimal string, such as "13.40".
+func (m Money) String() string {
+	units := MinorUnits(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	str := strconv.FormatInt(amount, 10)
+	if units == 0 {
+		return sign + str
+	}
+	if len(str) <= units {
+		str = strings.Repeat("0", units-len(str)+1) + str
+	}
+	return sign + str[:len(str)-units] + "." + str[len(str)-units:]
+}
+
+// Sub subtracts o from m. Both must have the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, errors.Errorf(
+			"currency mismatch: %s and %s", m.Currency, o.Currency,
+		)
+	}
+	return FromMinor(m.Amount-o.Amount, m.Currency), nil
+}
@@ -0,0 +1,78 @@
+package money
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMoney(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Money Suite")
+}
+
+var _ = Describe("Money", func() {
+	Describe("Parse", func() {
+		It("should parse decimal strings to minor units", func() {
+			m, err := Parse("13.40", "USD")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{1340, "USD"}))
+
+			m, err = Parse("-0.5", "USD")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{-50, "USD"}))
+
+			m, err = Parse("1200", "JPY")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{1200, "JPY"}))
+		})
+
+		It("should return error if amount has extra decimal places", func() {
+			_, err := Parse("13.405", "USD")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if amount is invalid", func() {
+			_, err := Parse("13,40", "USD")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if currency is invalid", func() {
+			_, err := Parse("13.40", "usd")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("FromFloat", func() {
+		It("should convert floats without drift", func() {
+			m, err := FromFloat(13.4, "USD")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{1340, "USD"}))
+
+			m, err = FromFloat(0.1+0.2, "USD")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{30, "USD"}))
+
+			m, err = FromFloat(2.675, "USD")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(Money{268, "USD"}))
+		})
+	})
+
+	Describe("String", func() {
+		It("should format minor units as decimal strings", func() {
+			Expect(Money{1340, "USD"}.String()).To(Equal("13.40"))
+			Expect(Money{-5, "USD"}.String()).To(Equal("-0.05"))
+			Expect(Money{1200, "JPY"}.String()).To(Equal("1200"))
+			Expect(Money{1, "KWD"}.String()).To(Equal("0.001"))
+		})
+	})
+
+	Describe("Sub", func() {
+		It("should return error if currencies differ", func() {
+			_, err := Money{100, "USD"}.Sub(Money{100, "CAD"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -5,6 +5,37 @@ echo "===> Changing directory to \"./test\""
 
 docker-compose up -d --build --force-recreate cassandra kafka mongo
 
+function init_mongo() {
+  docker exec mongo mongo -u root -p root --authenticationDatabase admin --quiet --eval '
+    if (rs.status().ok !== 1) {
+      rs.initiate({_id: "rs0", members: [{_id: 0, host: "mongo:27017"}]})
+    }
+    while (!db.isMaster().ismaster) {
+      sleep(500)
+    }
+  '
+  res=$?
+}
+
+echo "Waiting for Mongo replica-set to be ready."
+
+max_attempts=40
+cur_attempts=0
+init_mongo
+while (( res != 0 && ++cur_attempts != max_attempts ))
+do
+  init_mongo
+  echo Attempt: $cur_attempts of $max_attempts
+  sleep 1
+done
+
+if (( cur_attempts == max_attempts )); then
+  echo "Mongo Timed Out."
+  exit 1
+else
+  echo "Mongo replica-set initiated."
+fi
+
 function ping_cassandra() {
   docker exec -it cassandra /usr/bin/nodetool status | grep UN
   res=$?
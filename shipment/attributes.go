@@ -0,0 +1,252 @@
+package shipment
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// AttributeDef defines a custom attribute of shipments.
+type AttributeDef struct {
+	// Type is the JSON type of the attribute's value, which is one of
+	// "boolean", "integer", "number" or "string".
+	Type string `json:"type"`
+	// Required attributes must be set on every shipment.
+	Required bool `json:"required,omitempty"`
+	// Allowed restricts the attribute to these values, if specified.
+	Allowed []interface{} `json:"allowed,omitempty"`
+}
+
+// AttributeSchema defines the custom attributes which can be set in the
+// "attributes" of shipments, keyed by attribute-name. Attributes not defined
+// here are rejected if StrictFields is enabled, and are otherwise stored as-is.
+var AttributeSchema = map[string]AttributeDef{}
+
+// attributeTypes are the allowed types of attribute values.
+var attributeTypes = map[string]bool{
+	"boolean": true,
+	"integer": true,
+	"number":  true,
+	"string":  true,
+}
+
+// ParseAttributeSchema parses the JSON object of AttributeDefs, keyed by
+// attribute-name, and checks the definitions.
+func ParseAttributeSchema(data []byte) (map[string]AttributeDef, error) {
+	schema := map[string]AttributeDef{}
+	err := json.Unmarshal(data, &schema)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling attribute schema")
+		return nil, err
+	}
+
+	verrs := ValidationErrors{}
+	for _, name := range sortedDefs(schema) {
+		def := schema[name]
+		field := "attributes." + name
+		if name == "" || strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+			verrs = append(verrs, FieldError{field, "invalid attribute-name"})
+			continue
+		}
+		if !attributeTypes[def.Type] {
+			verrs = append(verrs, FieldError{field, "invalid type: " + def.Type})
+			continue
+		}
+		// Allowed values are checked only for their type
+		typeDef := AttributeDef{Type: def.Type}
+		for i, value := range def.Allowed {
+			value, err := typeDef.assert(value)
+			if err != nil {
+				verrs = append(verrs, FieldError{
+					fmt.Sprintf("%s.allowed.%d", field, i), err.Error(),
+				})
+				continue
+			}
+			def.Allowed[i] = value
+		}
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+	return schema, nil
+}
+
+// sortedDefs returns the attribute-names of schema in sorted order.
+func sortedDefs(schema map[string]AttributeDef) []string {
+	m := make(map[string]interface{}, len(schema))
+	for name := range schema {
+		m[name] = nil
+	}
+	return sortedKeys(m)
+}
+
+// assert checks if the value matches the AttributeDef, and returns the value
+// normalized for storing. Integers are stored as int64.
+func (def AttributeDef) assert(value interface{}) (interface{}, error) {
+	var normalized interface{}
+	switch def.Type {
+	case "boolean":
+		if v, isBool := value.(bool); isBool {
+			normalized = v
+		}
+	case "string":
+		if v, isString := value.(string); isString {
+			normalized = v
+		}
+	case "integer":
+		switch v := value.(type) {
+		case int:
+			normalized = int64(v)
+		case int32:
+			normalized = int64(v)
+		case int64:
+			normalized = v
+		case float64:
+			if v == float64(int64(v)) {
+				normalized = int64(v)
+			}
+		}
+	case "number":
+		switch v := value.(type) {
+		case int:
+			normalized = float64(v)
+		case int32:
+			normalized = float64(v)
+		case int64:
+			normalized = float64(v)
+		case float64:
+			normalized = v
+		}
+	}
+	if normalized == nil {
+		return nil, errors.Errorf("expected %s, got %s", def.Type, typeName(value))
+	}
+
+	if len(def.Allowed) == 0 {
+		return normalized, nil
+	}
+	for _, allowed := range def.Allowed {
+		if reflect.DeepEqual(allowed, normalized) {
+			return normalized, nil
+		}
+	}
+	return nil, errors.Errorf("expected one of %v, got %v", def.Allowed, value)
+}
+
+// isAttributePath checks if the key is the dotted path of a single attribute,
+// such as "attributes.organic".
+func isAttributePath(key string) bool {
+	name := strings.TrimPrefix(key, "attributes.")
+	return name != key && name != "" &&
+		!strings.HasPrefix(name, "$") && !strings.Contains(name, ".")
+}
+
+// checkAttribute checks and normalizes the value of the named attribute.
+// Nil values unset the attribute.
+func checkAttribute(name string, value interface{}) (interface{}, error) {
+	def, isDefined := AttributeSchema[name]
+	if !isDefined {
+		if StrictFields {
+			return nil, errors.New("unknown attribute")
+		}
+		return value, nil
+	}
+	if value == nil {
+		if def.Required {
+			return nil, errors.New("attribute is required")
+		}
+		return nil, nil
+	}
+	return def.assert(value)
+}
+
+// validateAttributes checks and normalizes the attributes against the
+// AttributeSchema, including that the required attributes are set.
+func validateAttributes(attrs map[string]interface{}) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, name := range sortedKeys(attrs) {
+		value, err := checkAttribute(name, attrs[name])
+		if err != nil {
+			verrs = append(verrs, FieldError{"attributes." + name, err.Error()})
+			continue
+		}
+		if value == nil {
+			delete(attrs, name)
+			continue
+		}
+		attrs[name] = value
+	}
+	// Required attributes with nil values are reported above
+	for _, name := range sortedDefs(AttributeSchema) {
+		if _, exists := attrs[name]; AttributeSchema[name].Required && !exists {
+			verrs = append(verrs, FieldError{"attributes." + name, "attribute is required"})
+		}
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// validateUpdateAttributes checks and normalizes the attributes in update,
+// which are either replaced using "attributes", or set individually using
+// their paths, such as "attributes.organic".
+func validateUpdateAttributes(update map[string]interface{}) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, key := range sortedKeys(update) {
+		if key == "attributes" {
+			attrs, _ := update[key].(map[string]interface{})
+			if attrs == nil {
+				attrs = map[string]interface{}{}
+			}
+			verrs = append(verrs, validateAttributes(attrs)...)
+			update[key] = attrs
+			continue
+		}
+		if !isAttributePath(key) {
+			continue
+		}
+		value, err := checkAttribute(strings.TrimPrefix(key, "attributes."), update[key])
+		if err != nil {
+			verrs = append(verrs, FieldError{key, err.Error()})
+			continue
+		}
+		update[key] = value
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// attributes reads the attributes object. Attributes read from Mongo are
+// BSON documents, which are converted to maps.
+func (d *mapDecoder) attributes(field string) map[string]interface{} {
+	switch v := d.m[field].(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return v
+	case bson.Marshaler:
+		doc, err := v.MarshalBSON()
+		if err != nil {
+			d.fail(field, err)
+			return nil
+		}
+		attrs := map[string]interface{}{}
+		err = bson.Unmarshal(doc, attrs)
+		if err != nil {
+			d.fail(field, err)
+			return nil
+		}
+		return attrs
+	}
+	d.invalid(field, "object")
+	return nil
+}
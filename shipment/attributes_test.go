@@ -0,0 +1,146 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("attributes", func() {
+	BeforeEach(func() {
+		schema, err := ParseAttributeSchema([]byte(`{
+			"organic": {"type": "boolean", "required": true},
+			"allergens": {"type": "string", "allowed": ["nuts", "gluten"]},
+			"poLine": {"type": "integer"}
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		AttributeSchema = schema
+	})
+
+	AfterEach(func() {
+		AttributeSchema = map[string]AttributeDef{}
+	})
+
+	It("should parse the attribute schema", func() {
+		Expect(AttributeSchema).To(Equal(map[string]AttributeDef{
+			"organic":   AttributeDef{Type: "boolean", Required: true},
+			"allergens": AttributeDef{Type: "string", Allowed: []interface{}{"nuts", "gluten"}},
+			"poLine":    AttributeDef{Type: "integer"},
+		}))
+	})
+
+	It("should reject invalid attribute definitions", func() {
+		_, err := ParseAttributeSchema([]byte(`{
+			"a.b": {"type": "string"},
+			"grade": {"type": "date"},
+			"size": {"type": "integer", "allowed": [1, "L"]}
+		}`))
+		Expect(err).To(Equal(ValidationErrors{
+			FieldError{"attributes.a.b", "invalid attribute-name"},
+			FieldError{"attributes.grade", "invalid type: date"},
+			FieldError{"attributes.size.allowed.1", "expected integer, got string"},
+		}))
+	})
+
+	It("should validate and normalize the attributes", func() {
+		attrs := map[string]interface{}{
+			"organic": true,
+			"poLine":  float64(12),
+		}
+		Expect(validateAttributes(attrs)).To(BeNil())
+		Expect(attrs["poLine"]).To(Equal(int64(12)))
+
+		verrs := validateAttributes(map[string]interface{}{
+			"allergens": "soy",
+			"grade":     "A",
+			"poLine":    1.5,
+		})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"attributes.allergens", "expected one of [nuts gluten], got soy"},
+			FieldError{"attributes.grade", "unknown attribute"},
+			FieldError{"attributes.poLine", "expected integer, got number"},
+			FieldError{"attributes.organic", "attribute is required"},
+		}))
+	})
+
+	It("should validate attributes set by updates", func() {
+		update := map[string]interface{}{
+			"attributes.organic": nil,
+			"attributes.poLine":  float64(3),
+		}
+		Expect(checkUpdatePolicy(update)).To(BeNil())
+		Expect(checkFields(update)).To(BeNil())
+		verrs := validateUpdateAttributes(update)
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"attributes.organic", "attribute is required"},
+		}))
+		Expect(update["attributes.poLine"]).To(Equal(int64(3)))
+
+		verrs = checkUpdatePolicy(map[string]interface{}{
+			"attributes":         "organic",
+			"attributes.organic": []interface{}{true},
+		})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"update.attributes", "expected object, got string"},
+			FieldError{"update.attributes.organic", "expected scalar, got array"},
+		}))
+	})
+
+	It("should filter using defined attributes", func() {
+		Expect(checkFilter(map[string]interface{}{
+			"attributes.organic": true,
+		})).To(BeNil())
+		Expect(checkFilter(map[string]interface{}{
+			"attributes":       map[string]interface{}{"organic": true},
+			"attributes.grade": "A",
+		})).To(HaveLen(2))
+	})
+
+	It("should patch attributes individually", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{
+			ItemID: itemID,
+			Attributes: map[string]interface{}{
+				"organic": true,
+				"poLine":  int64(12),
+			},
+		}
+		unpatched, patched, tested, err := patchShipment(current, &patchUpdate{
+			MergePatch: map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"organic":   false,
+					"allergens": "nuts",
+					"poLine":    12,
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		update, filter := patchDiff(current, unpatched, patched, tested)
+		Expect(update).To(HaveKeyWithValue("attributes.organic", false))
+		Expect(update).To(HaveKeyWithValue("attributes.allergens", "nuts"))
+		Expect(update).ToNot(HaveKey("attributes.poLine"))
+		Expect(update).ToNot(HaveKey("attributes"))
+		Expect(filter).To(Equal(map[string]interface{}{
+			"itemID":             itemID.String(),
+			"attributes.organic": true,
+			"attributes.allergens": map[string]interface{}{
+				"$in": []interface{}{nil, nil},
+			},
+		}))
+	})
+})
+
+var _ = Describe("attributes in insert", func() {
+	It("should return ValidationError if attributes are invalid", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"itemID":"2b7d7c7e-5d28-4a5c-9d7a-1c2f8e7b6a11","attributes":{"organic":"yes"}}`))
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("attributes.organic: unknown attribute"))
+	})
+})
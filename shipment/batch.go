@@ -0,0 +1,299 @@
+package shipment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// batchInsert is the Event-data for inserting a batch of shipments.
+type batchInsert struct {
+	Shipments []json.RawMessage `json:"shipments"`
+	// Ordered stops the batch at the first failed shipment, leaving the rest
+	// uninserted. Defaults to true.
+	Ordered *bool `json:"ordered,omitempty"`
+}
+
+// batchItemResult is the result of inserting a shipment from the batch,
+// at the specified index.
+type batchItemResult struct {
+	Index     int    `json:"index"`
+	ID        string `json:"_id,omitempty"`
+	ItemID    string `json:"itemID,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode int16  `json:"errorCode,omitempty"`
+}
+
+type batchInsertResult struct {
+	InsertedCount int               `json:"insertedCount"`
+	FailedCount   int               `json:"failedCount"`
+	Items         []batchItemResult `json:"items"`
+}
+
+// isBatchInsert checks if Event-data contains a batch of shipments, as either
+// an array, or an object with "shipments" key.
+func isBatchInsert(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return true
+	}
+	batch := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &batch)
+	if err != nil {
+		return false
+	}
+	_, isBatch := batch["shipments"]
+	return isBatch
+}
+
+// InsertBatch handles "insert" events containing a batch of shipments. Each
+// shipment is validated separately, and the result contains the success or
+// error of every shipment, in order.
+func InsertBatch(collection Collection, event *model.Event) *model.KafkaResponse {
+	batch := &batchInsert{}
+	var err error
+	if bytes.TrimSpace(event.Data)[0] == '[' {
+		err = json.Unmarshal(event.Data, &batch.Shipments)
+	} else {
+		err = json.Unmarshal(event.Data, batch)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "InsertBatch: Error while unmarshalling Event-data")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if len(batch.Shipments) == 0 {
+		err = errors.New("blank batch provided")
+		err = errors.Wrap(err, "InsertBatch")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	ordered := batch.Ordered == nil || *batch.Ordered
+
+	items, ships, docIndexes := prepareBatch(batch.Shipments, ordered)
+	if len(ships) > 0 {
+		stored, err := storedItemIDs(collection, ships)
+		if err != nil {
+			err = errors.Wrap(err, "InsertBatch: Error finding stored ItemIDs")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		inserted := insertShipments(collection, stored, items, ships, docIndexes, ordered)
+		// The command succeeded, so errors in publishing are only logged
+		err = publishCreated(event, inserted)
+		if err != nil {
+			err = errors.Wrap(err, "InsertBatch")
+			log.Println(err)
+		}
+	}
+
+	result := &batchInsertResult{
+		Items: items,
+	}
+	for _, item := range items {
+		if item.Error == "" {
+			result.InsertedCount++
+		} else {
+			result.FailedCount++
+		}
+	}
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "InsertBatch: Error marshalling Shipment InsertBatch-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
+
+// prepareBatch parses the shipments in batch, and returns the result for each
+// shipment, along with the valid shipments to be inserted and their indexes
+// in batch. ObjectIDs are assigned beforehand, so these are known even if
+// the insert partially fails. If ordered, the shipments after the first
+// invalid one are skipped.
+func prepareBatch(
+	batch []json.RawMessage, ordered bool,
+) ([]batchItemResult, []*Shipment, []int) {
+	items := make([]batchItemResult, len(batch))
+	ships := []*Shipment{}
+	docIndexes := []int{}
+	batchItemIDs := map[string]int{}
+
+	for i, data := range batch {
+		items[i].Index = i
+		if ordered && i > 0 && items[i-1].Error != "" {
+			items[i].Error = "skipped after earlier error in ordered batch"
+			items[i].ErrorCode = InternalError
+			continue
+		}
+
+		ship, err := parseInsert(data)
+		if err == nil {
+			itemID := ship.ItemID.String()
+			if prevIndex, exists := batchItemIDs[itemID]; exists {
+				err = ValidationErrors{FieldError{
+					"itemID",
+					fmt.Sprintf("duplicates shipment at index %d", prevIndex),
+				}}
+			} else {
+				batchItemIDs[itemID] = i
+			}
+		}
+		if err != nil {
+			items[i].Error = err.Error()
+			items[i].ErrorCode = validationCode(err, InternalError)
+			continue
+		}
+
+		ship.ID = objectid.New()
+		items[i].ID = ship.ID.Hex()
+		items[i].ItemID = ship.ItemID.String()
+		ships = append(ships, ship)
+		docIndexes = append(docIndexes, i)
+	}
+	return items, ships, docIndexes
+}
+
+// insertShipments inserts the valid shipments using InsertMany, and returns
+// the inserted shipments. Duplicate keys would fail the transaction the Event
+// is handled in, so shipments with stored ItemIDs are not inserted. The error
+// of each failed shipment is set in its result, using the indexes of the
+// write-errors. Ordered batches stop at the first failed shipment.
+func insertShipments(
+	collection Collection,
+	stored map[string]bool,
+	items []batchItemResult, ships []*Shipment, docIndexes []int, ordered bool,
+) []*Shipment {
+	docs := []interface{}{}
+	insertIndexes := []int{}
+	for j, ship := range ships {
+		if stored[ship.ItemID.String()] {
+			failBatchItem(items, docIndexes[j], ValidationErrors{
+				FieldError{"itemID", "already exists"},
+			})
+			if ordered {
+				skipBatchItems(items, docIndexes[j+1:])
+				break
+			}
+			continue
+		}
+		docs = append(docs, ship)
+		insertIndexes = append(insertIndexes, j)
+	}
+	if len(docs) == 0 {
+		return []*Shipment{}
+	}
+
+	failed := map[int]error{}
+	_, err := collection.InsertMany(docs, ordered)
+	if err != nil {
+		bulkErr, isBulkErr := errors.Cause(err).(mgo.BulkWriteError)
+		if !isBulkErr || len(bulkErr.WriteErrors) == 0 {
+			// The failure is not specific to shipments, so all of these failed
+			for k := range docs {
+				failed[k] = err
+			}
+		} else {
+			for _, writeErr := range bulkErr.WriteErrors {
+				failed[writeErr.Index] = mgo.WriteErrors{writeErr}
+			}
+		}
+	}
+
+	inserted := []*Shipment{}
+	for k, j := range insertIndexes {
+		err, isFailed := failed[k]
+		if !isFailed {
+			inserted = append(inserted, ships[j])
+			continue
+		}
+		failBatchItem(items, docIndexes[j], err)
+		if ordered {
+			skipped := []int{}
+			for _, j := range insertIndexes[k+1:] {
+				skipped = append(skipped, docIndexes[j])
+			}
+			skipBatchItems(items, skipped)
+			break
+		}
+	}
+	return inserted
+}
+
+// failBatchItem sets the error of the shipment at index i of the batch.
+func failBatchItem(items []batchItemResult, i int, err error) {
+	err = errors.Wrap(err, "InsertBatch: Error Inserting shipment into Mongo")
+	log.Println(err)
+	items[i].ID = ""
+	items[i].Error = err.Error()
+	items[i].ErrorCode = validationCode(err, DatabaseError)
+}
+
+// skipBatchItems marks the shipments at the indexes of the batch as skipped,
+// after an earlier error in an ordered batch.
+func skipBatchItems(items []batchItemResult, indexes []int) {
+	for _, i := range indexes {
+		items[i].ID = ""
+		items[i].Error = "skipped after earlier error in ordered batch"
+		items[i].ErrorCode = InternalError
+	}
+}
+
+// storedItemIDs returns the ItemIDs of the shipments which are already stored,
+// including soft-deleted shipments.
+func storedItemIDs(collection Collection, ships []*Shipment) (map[string]bool, error) {
+	itemIDs := make([]interface{}, len(ships))
+	for i, ship := range ships {
+		itemIDs[i] = ship.ItemID.String()
+	}
+	stored, err := findShipments(collection, map[string]interface{}{
+		"itemID": map[string]interface{}{
+			"$in": itemIDs,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	storedIDs := map[string]bool{}
+	for _, ship := range stored {
+		storedIDs[ship.ItemID.String()] = true
+	}
+	return storedIDs, nil
+}
@@ -0,0 +1,131 @@
+package shipment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/TerrexTech/uuuid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("batch insert", func() {
+	It("should return error if batch is empty", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"shipments":[]}`))
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("blank batch provided"))
+	})
+
+	It("should detect batches as arrays or shipments-objects", func() {
+		Expect(isBatchInsert([]byte(` [{"lot": "A1"}]`))).To(BeTrue())
+		Expect(isBatchInsert([]byte(`{"shipments": []}`))).To(BeTrue())
+		Expect(isBatchInsert([]byte(`{"lot": "A1"}`))).To(BeFalse())
+	})
+
+	It("should skip shipments after an invalid one in ordered batch", func() {
+		batch := []json.RawMessage{
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"}`),
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1f", "quantity": "x"}`),
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c20"}`),
+		}
+		items, ships, docIndexes := prepareBatch(batch, true)
+		Expect(ships).To(HaveLen(1))
+		Expect(docIndexes).To(Equal([]int{0}))
+		Expect(items[0].Error).To(BeEmpty())
+		Expect(items[1].ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(items[1].Error).To(ContainSubstring("quantity"))
+		Expect(items[2].Error).To(ContainSubstring("skipped"))
+	})
+
+	It("should insert valid shipments and report duplicates in unordered batch", func() {
+		batch := []json.RawMessage{
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"}`),
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"}`),
+			json.RawMessage(`{"lot": "A1"}`),
+			json.RawMessage(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c20"}`),
+		}
+		items, ships, docIndexes := prepareBatch(batch, false)
+		Expect(ships).To(HaveLen(2))
+		Expect(docIndexes).To(Equal([]int{0, 3}))
+		Expect(items[1].Error).To(ContainSubstring("duplicates shipment at index 0"))
+		Expect(items[2].Error).To(ContainSubstring("missing ItemID"))
+		Expect(items[3].Error).To(BeEmpty())
+		Expect(items[3].ItemID).To(Equal("d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c20"))
+	})
+
+	It("should record insert errors, and skip the rest of ordered batches", func() {
+		items := make([]batchItemResult, 4)
+		ships := make([]*Shipment, 3)
+		for i := range items {
+			items[i] = batchItemResult{Index: i, ID: "id"}
+		}
+		for j := range ships {
+			ships[j] = &Shipment{Lot: fmt.Sprintf("A%d", j)}
+		}
+		orders := []bool{}
+		collection := &fakeCollection{
+			insertMany: func(data []interface{}, ordered bool) (*mgo.InsertManyResult, error) {
+				orders = append(orders, ordered)
+				Expect(data).To(HaveLen(3))
+				// Write-errors are reported by the index of the document
+				return &mgo.InsertManyResult{}, mgo.BulkWriteError{
+					WriteErrors: mgo.WriteErrors{
+						mgo.WriteError{Index: 1, Code: duplicateKeyCode, Message: "duplicate key"},
+					},
+				}
+			},
+		}
+
+		inserted := insertShipments(collection, nil, items, ships, []int{0, 2, 3}, true)
+		Expect(inserted).To(Equal(ships[:1]))
+		Expect(items[0].Error).To(BeEmpty())
+		Expect(items[0].ID).To(Equal("id"))
+		Expect(items[2].Error).To(ContainSubstring("duplicate key"))
+		Expect(items[2].ErrorCode).To(Equal(int16(DatabaseError)))
+		Expect(items[3].Error).To(ContainSubstring("skipped"))
+
+		items[2], items[3] = batchItemResult{Index: 2}, batchItemResult{Index: 3}
+		inserted = insertShipments(collection, nil, items, ships, []int{0, 2, 3}, false)
+		Expect(inserted).To(Equal([]*Shipment{ships[0], ships[2]}))
+		Expect(items[3].Error).To(BeEmpty())
+		Expect(orders).To(Equal([]bool{true, false}))
+		Expect(items[3].Error).To(BeEmpty())
+	})
+
+	It("should not insert shipments whose ItemIDs are stored", func() {
+		storedID, err := uuuid.FromString("d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e")
+		Expect(err).ToNot(HaveOccurred())
+		insertedIDs := []string{}
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{&Shipment{ItemID: storedID}}, nil
+			},
+			insertMany: func(data []interface{}, ordered bool) (*mgo.InsertManyResult, error) {
+				for _, ship := range data {
+					insertedIDs = append(insertedIDs, ship.(*Shipment).ItemID.String())
+				}
+				return &mgo.InsertManyResult{}, nil
+			},
+		}
+
+		kr := Insert(collection, newMockEvent("insert", []byte(`{"ordered": false, "shipments": [
+			{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"},
+			{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1f"}
+		]}`)))
+		Expect(kr.Error).To(BeEmpty())
+		Expect(insertedIDs).To(Equal([]string{"d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1f"}))
+
+		result := &batchInsertResult{}
+		err = json.Unmarshal(kr.Result, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.InsertedCount).To(Equal(1))
+		Expect(result.Items[0].ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(result.Items[0].Error).To(ContainSubstring("already exists"))
+	})
+})
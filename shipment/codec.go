@@ -0,0 +1,343 @@
+package shipment
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// fieldKind is how a field is converted when stored, rendered and decoded.
+type fieldKind string
+
+const (
+	plainField      fieldKind = ""
+	attributesField fieldKind = "attributes"
+	dateField       fieldKind = "date"
+	idField         fieldKind = "id"
+	moneyField      fieldKind = "money"
+	timestampField  fieldKind = "timestamp"
+	uuidField       fieldKind = "uuid"
+	weightField     fieldKind = "weight"
+	weightUnitField fieldKind = "weightUnit"
+)
+
+// fieldCodec converts a stored field of shipments.
+type fieldCodec struct {
+	Name string
+	Kind fieldKind
+	// Stored is the type of the field's value as stored in Mongo.
+	Stored reflect.Type
+	// Index is the index of the Shipment field. It is -1 for computed fields.
+	Index int
+	// Compute returns the value of computed fields.
+	Compute func(i *Shipment, now time.Time) interface{}
+}
+
+// computedCodecs are the stored fields computed from other Shipment fields.
+var computedCodecs = []fieldCodec{
+	fieldCodec{
+		Name: "currency",
+		Compute: func(i *Shipment, now time.Time) interface{} {
+			return i.currency()
+		},
+	},
+	fieldCodec{
+		Name: "daysUntilExpiry",
+		Compute: func(i *Shipment, now time.Time) interface{} {
+			return i.daysUntilExpiry(now)
+		},
+	},
+	fieldCodec{
+		Name: "margin",
+		Kind: moneyField,
+		Compute: func(i *Shipment, now time.Time) interface{} {
+			return i.margin()
+		},
+	},
+	fieldCodec{
+		Name: "remainingWeight",
+		Kind: weightField,
+		Compute: func(i *Shipment, now time.Time) interface{} {
+			return i.remainingWeight()
+		},
+	},
+	fieldCodec{
+		Name: "sellThrough",
+		Compute: func(i *Shipment, now time.Time) interface{} {
+			return i.sellThrough()
+		},
+	},
+}
+
+// shipmentCodecs are the codecs of all stored fields, in the order these are
+// stored. These are built from the "json" and "codec" tags of Shipment fields,
+// followed by the computedCodecs.
+var shipmentCodecs = buildCodecs(reflect.TypeOf(Shipment{}), computedCodecs)
+
+// storedType is the struct-type of shipments as stored in Mongo, with the
+// Stored type of each codec. Zero values are omitted.
+var storedType = buildStoredType(shipmentCodecs)
+
+var (
+	uuidType     = reflect.TypeOf(uuuid.UUID{})
+	objectIDType = reflect.TypeOf(objectid.ObjectID{})
+	moneyType    = reflect.TypeOf(money.Money{})
+)
+
+// buildCodecs creates the codecs for fields of struct-type t, and appends the
+// computed codecs. The Kind of fields is set by their "codec" tag, or is
+// inferred from their type.
+func buildCodecs(t reflect.Type, computed []fieldCodec) []fieldCodec {
+	codecs := []fieldCodec{}
+	for f := 0; f < t.NumField(); f++ {
+		field := t.Field(f)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		codec := fieldCodec{
+			Name:  name,
+			Kind:  fieldKind(field.Tag.Get("codec")),
+			Index: f,
+		}
+		if codec.Kind == plainField {
+			switch field.Type {
+			case objectIDType:
+				codec.Kind = idField
+			case uuidType:
+				codec.Kind = uuidField
+			case moneyType:
+				codec.Kind = moneyField
+			}
+		}
+		codec.Stored = storedKind(codec.Kind, field.Type)
+		codecs = append(codecs, codec)
+	}
+
+	for _, codec := range computed {
+		codec.Index = -1
+		value := codec.Compute(&Shipment{}, time.Time{})
+		codec.Stored = storedKind(codec.Kind, reflect.TypeOf(value))
+		codecs = append(codecs, codec)
+	}
+	return codecs
+}
+
+// storedKind returns the type of values of kind as stored in Mongo.
+func storedKind(kind fieldKind, t reflect.Type) reflect.Type {
+	switch kind {
+	case uuidField:
+		return reflect.TypeOf("")
+	case moneyField:
+		return reflect.TypeOf(int64(0))
+	}
+	return t
+}
+
+// buildStoredType creates the struct-type with a field for each codec.
+func buildStoredType(codecs []fieldCodec) reflect.Type {
+	fields := make([]reflect.StructField, len(codecs))
+	for i, codec := range codecs {
+		tag := codec.Name + ",omitempty"
+		fields[i] = reflect.StructField{
+			Name: "F" + strings.Title(codec.Name),
+			Type: codec.Stored,
+			Tag:  reflect.StructTag(`bson:"` + tag + `" json:"` + tag + `"`),
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// codecNames returns the names of Shipment fields with the specified kinds.
+// Computed fields are not included.
+func codecNames(kinds ...fieldKind) []string {
+	names := []string{}
+	for _, codec := range shipmentCodecs {
+		if codec.Index < 0 {
+			continue
+		}
+		for _, kind := range kinds {
+			if codec.Kind == kind {
+				names = append(names, codec.Name)
+			}
+		}
+	}
+	return names
+}
+
+// value returns the field's value in Shipment, or its computed value.
+func (c fieldCodec) value(i *Shipment, now time.Time) interface{} {
+	if c.Index < 0 {
+		return c.Compute(i, now)
+	}
+	return reflect.ValueOf(i).Elem().Field(c.Index).Interface()
+}
+
+// stored converts the field's value to its stored value.
+func (c fieldCodec) stored(value interface{}) interface{} {
+	switch c.Kind {
+	case uuidField:
+		return value.(uuuid.UUID).String()
+	case moneyField:
+		return value.(money.Money).Amount
+	}
+	return value
+}
+
+// rendered converts the field's value to its value in JSON responses. Weights
+// are rendered in unit.
+func (c fieldCodec) rendered(value interface{}, unit weight.Unit) interface{} {
+	switch c.Kind {
+	case idField:
+		return value.(objectid.ObjectID).Hex()
+	case uuidField:
+		return value.(uuuid.UUID).String()
+	case moneyField:
+		return value.(money.Money).String()
+	case weightField:
+		return weight.Convert(value.(float64), weight.Canonical, unit)
+	}
+	return value
+}
+
+// decode reads the field's value from the mapDecoder.
+func (c fieldCodec) decode(d *mapDecoder, currency string, t reflect.Type) interface{} {
+	switch c.Kind {
+	case attributesField:
+		return d.attributes(c.Name)
+	case dateField:
+		return d.timestamp(c.Name, true)
+	case idField:
+		return d.objectID(c.Name)
+	case moneyField:
+		return d.money(c.Name, currency)
+	case timestampField:
+		return d.timestamp(c.Name, false)
+	case uuidField:
+		return d.uuid(c.Name)
+	case weightUnitField:
+		return d.weightUnit(c.Name)
+	}
+	switch t.Kind() {
+	case reflect.Float64:
+		return d.float64(c.Name)
+	case reflect.Int64:
+		return d.int64(c.Name)
+	}
+	return d.string(c.Name)
+}
+
+// fromStored converts the field's value, as stored in Mongo and decoded into
+// the storedType, to its value in Shipment. Like decode, missing prices have
+// no currency, and timestamps and weight units are checked.
+func (c fieldCodec) fromStored(value interface{}, currency string) (interface{}, error) {
+	switch c.Kind {
+	case dateField:
+		return assertTimestamp(value, true)
+	case moneyField:
+		amount := value.(int64)
+		if amount == 0 {
+			return money.Money{}, nil
+		}
+		return money.FromMinor(amount, currency), nil
+	case timestampField:
+		return assertTimestamp(value, false)
+	case uuidField:
+		str := value.(string)
+		if str == "" {
+			return uuuid.UUID{}, nil
+		}
+		id, err := uuuid.FromString(str)
+		if err != nil {
+			return nil, errors.Errorf("expected UUID string, got invalid UUID: %s", str)
+		}
+		return id, nil
+	case weightUnitField:
+		symbol := value.(string)
+		if symbol == "" {
+			return "", nil
+		}
+		unit, err := weight.ParseUnit(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return string(unit), nil
+	}
+	return value, nil
+}
+
+// codecIndex returns the index of the named field in shipmentCodecs, which
+// is also its index in storedType.
+func codecIndex(name string) int {
+	for i, codec := range shipmentCodecs {
+		if codec.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// storedCurrency is the index of the currency in storedType.
+var storedCurrency = codecIndex("currency")
+
+// unmarshalStored sets the Shipment fields from doc, the shipment as decoded
+// into the storedType. An error is returned for the first invalid field.
+func (i *Shipment) unmarshalStored(doc reflect.Value) error {
+	// Prices are stored without currency, so currency is read first
+	currency := doc.Field(storedCurrency).String()
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	fields := reflect.ValueOf(i).Elem()
+	for f, codec := range shipmentCodecs {
+		if codec.Index < 0 {
+			continue
+		}
+		value, err := codec.fromStored(doc.Field(f).Interface(), currency)
+		if err != nil {
+			return errors.Wrap(err, codec.Name)
+		}
+		fields.Field(codec.Index).Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// storedValues returns the values of all stored fields, as stored in Mongo.
+// Zero values are included.
+func (i *Shipment) storedValues() map[string]interface{} {
+	now := time.Now()
+	values := make(map[string]interface{}, len(shipmentCodecs))
+	for _, codec := range shipmentCodecs {
+		values[codec.Name] = codec.stored(codec.value(i, now))
+	}
+	return values
+}
+
+// storedFields returns the values of specified fields, as stored in Mongo.
+func (i *Shipment) storedFields(fields []string) map[string]interface{} {
+	stored := i.storedValues()
+	values := map[string]interface{}{}
+	for _, field := range fields {
+		if value, isStored := stored[field]; isStored {
+			values[field] = value
+		}
+	}
+	return values
+}
+
+// storedDoc returns the shipment as the storedType, for marshalling to BSON.
+func (i *Shipment) storedDoc() interface{} {
+	now := time.Now()
+	doc := reflect.New(storedType).Elem()
+	for f, codec := range shipmentCodecs {
+		doc.Field(f).Set(reflect.ValueOf(codec.stored(codec.value(i, now))))
+	}
+	return doc.Addr().Interface()
+}
@@ -0,0 +1,202 @@
+package shipment
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// codecShipment returns a shipment with fields of each kind set.
+func codecShipment() (*Shipment, error) {
+	itemID, err := uuuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	deviceID, err := uuuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	return &Shipment{
+		ID:          objectid.New(),
+		ItemID:      itemID,
+		Attributes:  map[string]interface{}{"organic": true, "poLine": int64(12)},
+		Barcode:     "00012345678905",
+		DateArrived: 1540000000,
+		DeviceID:    deviceID,
+		ExpiryDate:  1541030400,
+		Lot:         "A1",
+		Price:       money.FromMinor(1340, "EUR"),
+		SalePrice:   money.FromMinor(1500, "EUR"),
+		SoldWeight:  2.5,
+		TotalWeight: 10,
+		WeightUnit:  "lb",
+	}, nil
+}
+
+var _ = Describe("UnmarshalBSON", func() {
+	It("should decode shipments as marshalled by MarshalBSON", func() {
+		ship, err := codecShipment()
+		Expect(err).ToNot(HaveOccurred())
+		in, err := ship.MarshalBSON()
+		Expect(err).ToNot(HaveOccurred())
+
+		decoded := &Shipment{}
+		err = decoded.UnmarshalBSON(in)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal(ship))
+
+		// Without falling back to decoding into a map
+		doc := reflect.New(storedType)
+		err = bson.Unmarshalv2(in, doc.Interface())
+		Expect(err).ToNot(HaveOccurred())
+		stored := &Shipment{}
+		err = stored.unmarshalStored(doc.Elem())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored).To(Equal(ship))
+	})
+
+	It("should convert numbers stored with other BSON types", func() {
+		in, err := bson.NewDocument(
+			bson.EC.String("itemID", "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"),
+			bson.EC.Int32("totalWeight", 10),
+			bson.EC.Int32("quantity", 4),
+			bson.EC.Int32("price", 1340),
+		).MarshalBSON()
+		Expect(err).ToNot(HaveOccurred())
+
+		decoded := &Shipment{}
+		err = decoded.UnmarshalBSON(in)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded.TotalWeight).To(Equal(10.0))
+		Expect(decoded.Quantity).To(Equal(int64(4)))
+		Expect(decoded.Price).To(Equal(money.FromMinor(1340, DefaultCurrency)))
+	})
+
+	It("should report every invalid field", func() {
+		in, err := bson.NewDocument(
+			bson.EC.String("itemID", "x"),
+			bson.EC.String("totalWeight", "10"),
+			bson.EC.String("weightUnit", "stone"),
+		).MarshalBSON()
+		Expect(err).ToNot(HaveOccurred())
+
+		err = (&Shipment{}).UnmarshalBSON(in)
+		verrs, isVerrs := err.(ValidationErrors)
+		Expect(isVerrs).To(BeTrue())
+		fields := []string{}
+		for _, verr := range verrs {
+			fields = append(fields, verr.Field)
+		}
+		Expect(fields).To(ConsistOf("itemID", "totalWeight", "weightUnit"))
+	})
+})
+
+var _ = Describe("codecs", func() {
+	It("should store every Shipment field and the computed fields", func() {
+		fields := jsonFields(reflect.TypeOf(Shipment{}))
+		for _, codec := range computedCodecs {
+			fields = append(fields, codec.Name)
+		}
+		sort.Strings(fields)
+		Expect(knownFields).To(Equal(fields))
+	})
+
+	It("should build the field lists from codec tags", func() {
+		Expect(weightFields).To(Equal([]string{
+			"donateWeight", "soldWeight", "totalWeight", "wasteWeight",
+		}))
+		Expect(dateFields).To(Equal([]string{
+			"dateArrived", "dateHeld", "dateRecalled", "dateSold",
+			"deletedAt", "expiryDate", "timestamp",
+		}))
+		Expect(dateOnlyFields).To(Equal(map[string]bool{"expiryDate": true}))
+	})
+
+	It("should decode the stored shipment", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		ship := &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Attributes:  map[string]interface{}{"organic": true},
+			ExpiryDate:  1540000000,
+			Lot:         "A1",
+			Price:       money.FromMinor(1340, "EUR"),
+			Quantity:    4,
+			SalePrice:   money.FromMinor(1500, "EUR"),
+			TotalWeight: 10,
+			WeightUnit:  "lb",
+		}
+		stored := ship.storedValues()
+		Expect(stored["itemID"]).To(Equal(itemID.String()))
+		Expect(stored["price"]).To(Equal(int64(1340)))
+		Expect(stored["currency"]).To(Equal("EUR"))
+		Expect(stored["margin"]).To(Equal(int64(160)))
+		Expect(stored["totalWeight"]).To(Equal(10.0))
+
+		decoded := &Shipment{}
+		err = decoded.unmarshalFromMap(stored)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal(ship))
+	})
+
+	It("should render weights and prices in JSON", func() {
+		ship := &Shipment{
+			Price:       money.FromMinor(1340, "USD"),
+			TotalWeight: 2,
+			WeightUnit:  "g",
+		}
+		shipJSON, err := json.Marshal(ship)
+		Expect(err).ToNot(HaveOccurred())
+		rendered := map[string]interface{}{}
+		err = json.Unmarshal(shipJSON, &rendered)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rendered).ToNot(HaveKey("_id"))
+		Expect(rendered["price"]).To(Equal("13.40"))
+		Expect(rendered["totalWeight"]).To(Equal(2000.0))
+		Expect(rendered["remainingWeight"]).To(Equal(2000.0))
+	})
+})
+
+// BenchmarkUnmarshalBSON compares decoding into the storedType with decoding
+// into a map, as UnmarshalBSON falls back to for invalid documents.
+func BenchmarkUnmarshalBSON(b *testing.B) {
+	ship, err := codecShipment()
+	if err != nil {
+		b.Fatal(err)
+	}
+	in, err := ship.MarshalBSON()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("storedType", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			err := (&Shipment{}).UnmarshalBSON(in)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			m := map[string]interface{}{}
+			err := bson.Unmarshal(in, m)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = (&Shipment{}).unmarshalFromMap(m)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
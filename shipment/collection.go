@@ -0,0 +1,17 @@
+package shipment
+
+import (
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+)
+
+// Collection is the Mongo collection of shipments, as used by Handlers.
+// It is implemented by sessionCollection.
+type Collection interface {
+	CountDocuments(filter interface{}) (int64, error)
+	DeleteMany(filter interface{}) (*mgo.DeleteResult, error)
+	Find(filter interface{}, opts ...findopt.Find) ([]interface{}, error)
+	InsertMany(data []interface{}, ordered bool) (*mgo.InsertManyResult, error)
+	InsertOne(data interface{}) (*mgo.InsertOneResult, error)
+	UpdateMany(filter interface{}, update interface{}) (*mgo.UpdateResult, error)
+}
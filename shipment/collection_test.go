@@ -0,0 +1,66 @@
+package shipment
+
+import (
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/pkg/errors"
+)
+
+// fakeCollection is a Collection whose operations are set by tests.
+// Operations which are not set fail.
+type fakeCollection struct {
+	countDocuments func(filter interface{}) (int64, error)
+	deleteMany     func(filter interface{}) (*mgo.DeleteResult, error)
+	find           func(filter interface{}) ([]interface{}, error)
+	insertMany     func(data []interface{}, ordered bool) (*mgo.InsertManyResult, error)
+	insertOne      func(data interface{}) (*mgo.InsertOneResult, error)
+	updateMany     func(filter interface{}, update interface{}) (*mgo.UpdateResult, error)
+}
+
+func (c *fakeCollection) CountDocuments(filter interface{}) (int64, error) {
+	if c.countDocuments == nil {
+		return 0, errors.New("unexpected CountDocuments")
+	}
+	return c.countDocuments(filter)
+}
+
+func (c *fakeCollection) DeleteMany(filter interface{}) (*mgo.DeleteResult, error) {
+	if c.deleteMany == nil {
+		return nil, errors.New("unexpected DeleteMany")
+	}
+	return c.deleteMany(filter)
+}
+
+func (c *fakeCollection) Find(
+	filter interface{}, opts ...findopt.Find,
+) ([]interface{}, error) {
+	if c.find == nil {
+		return nil, errors.New("unexpected Find")
+	}
+	return c.find(filter)
+}
+
+func (c *fakeCollection) InsertMany(
+	data []interface{}, ordered bool,
+) (*mgo.InsertManyResult, error) {
+	if c.insertMany == nil {
+		return nil, errors.New("unexpected InsertMany")
+	}
+	return c.insertMany(data, ordered)
+}
+
+func (c *fakeCollection) InsertOne(data interface{}) (*mgo.InsertOneResult, error) {
+	if c.insertOne == nil {
+		return nil, errors.New("unexpected InsertOne")
+	}
+	return c.insertOne(data)
+}
+
+func (c *fakeCollection) UpdateMany(
+	filter interface{}, update interface{},
+) (*mgo.UpdateResult, error) {
+	if c.updateMany == nil {
+		return nil, errors.New("unexpected UpdateMany")
+	}
+	return c.updateMany(filter, update)
+}
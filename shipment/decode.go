@@ -0,0 +1,174 @@
+package shipment
+
+import (
+	"fmt"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// mapDecoder reads fields from a map, collecting the errors for all invalid
+// fields instead of stopping at the first one. Invalid fields are left unset.
+type mapDecoder struct {
+	m     map[string]interface{}
+	verrs ValidationErrors
+}
+
+// typeName describes the type of value, using JSON type-names where possible.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case float32, float64, int, int32, int64:
+		return "number"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (d *mapDecoder) invalid(field string, expected string) {
+	d.verrs = append(d.verrs, FieldError{
+		field,
+		fmt.Sprintf("expected %s, got %s", expected, typeName(d.m[field])),
+	})
+}
+
+func (d *mapDecoder) fail(field string, err error) {
+	d.verrs = append(d.verrs, FieldError{field, err.Error()})
+}
+
+func (d *mapDecoder) string(field string) string {
+	if d.m[field] == nil {
+		return ""
+	}
+	v, assertOK := d.m[field].(string)
+	if !assertOK {
+		d.invalid(field, "string")
+	}
+	return v
+}
+
+func (d *mapDecoder) int64(field string) int64 {
+	switch v := d.m[field].(type) {
+	case nil:
+		return 0
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		if v != float64(int64(v)) {
+			d.invalid(field, "integer")
+			return 0
+		}
+		return int64(v)
+	}
+	d.invalid(field, "integer")
+	return 0
+}
+
+func (d *mapDecoder) float64(field string) float64 {
+	switch v := d.m[field].(type) {
+	case nil:
+		return 0
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	d.invalid(field, "number")
+	return 0
+}
+
+func (d *mapDecoder) uuid(field string) uuuid.UUID {
+	if d.m[field] == nil {
+		return uuuid.UUID{}
+	}
+	str, assertOK := d.m[field].(string)
+	if !assertOK {
+		d.invalid(field, "UUID string")
+		return uuuid.UUID{}
+	}
+	id, err := uuuid.FromString(str)
+	if err != nil {
+		d.fail(field, fmt.Errorf("expected UUID string, got invalid UUID: %s", str))
+		return uuuid.UUID{}
+	}
+	return id
+}
+
+func (d *mapDecoder) objectID(field string) objectid.ObjectID {
+	switch v := d.m[field].(type) {
+	case nil:
+		return objectid.NilObjectID
+	case objectid.ObjectID:
+		return v
+	case string:
+		id, err := objectid.FromHex(v)
+		if err != nil {
+			d.fail(field, fmt.Errorf("expected ObjectID hex-string, got: %s", v))
+			return objectid.NilObjectID
+		}
+		return id
+	}
+	d.invalid(field, "ObjectID")
+	return objectid.NilObjectID
+}
+
+func (d *mapDecoder) timestamp(field string, dateOnly bool) int64 {
+	if d.m[field] == nil {
+		return 0
+	}
+	ts, err := assertTimestamp(d.m[field], dateOnly)
+	if err != nil {
+		d.fail(field, err)
+	}
+	return ts
+}
+
+func (d *mapDecoder) money(field string, currency string) money.Money {
+	if d.m[field] == nil {
+		return money.Money{}
+	}
+	m, err := assertMoney(d.m[field], currency)
+	if err != nil {
+		d.fail(field, err)
+	}
+	return m
+}
+
+func (d *mapDecoder) weightUnit(field string) string {
+	symbol := d.string(field)
+	if symbol == "" {
+		return ""
+	}
+	unit, err := weight.ParseUnit(symbol)
+	if err != nil {
+		d.fail(field, err)
+		return ""
+	}
+	return string(unit)
+}
+
+// err returns the collected ValidationErrors, or nil if there are none.
+func (d *mapDecoder) err() error {
+	if len(d.verrs) == 0 {
+		return nil
+	}
+	return d.verrs
+}
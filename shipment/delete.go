@@ -3,23 +3,62 @@ package shipment
 import (
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/TerrexTech/go-eventstore-models/model"
-	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/uuuid"
 	"github.com/pkg/errors"
 )
 
+// deleteMode is the operation performed by delete-commands on matching shipments.
+type deleteMode int
+
+const (
+	// softDelete marks shipments as deleted.
+	softDelete deleteMode = iota
+	// purgeDelete removes shipments from Mongo, whether or not these are
+	// marked as deleted.
+	purgeDelete
+	// restoreDeleted removes the deleted-mark from shipments.
+	restoreDeleted
+)
+
 type deleteResult struct {
-	DeletedCount int64 `json:"deletedCount,omitempty"`
+	DeletedCount  int64       `json:"deletedCount,omitempty"`
+	RestoredCount int64       `json:"restoredCount,omitempty"`
+	Shipments     []*Shipment `json:"shipments,omitempty"`
+	// Truncated is true if only the first MaxReturned shipments are returned.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Delete handles "delete" events. Shipments are soft-deleted, by marking these
+// with the time and user of deletion. Deleted shipments are excluded from other
+// commands, and can be restored, or removed using "purge" service-action.
+func Delete(collection Collection, event *model.Event) *model.KafkaResponse {
+	return deleteShipments(collection, event, "Delete", softDelete)
 }
 
-// Delete handles "delete" events.
-func Delete(collection *mongo.Collection, event *model.Event) *model.KafkaResponse {
+// Purge handles "purge" service-action. It removes the shipments from Mongo,
+// including the soft-deleted shipments.
+func Purge(collection Collection, event *model.Event) *model.KafkaResponse {
+	return deleteShipments(collection, event, "Purge", purgeDelete)
+}
+
+// Restore handles "restore" service-action. It restores the soft-deleted shipments.
+func Restore(collection Collection, event *model.Event) *model.KafkaResponse {
+	return deleteShipments(collection, event, "Restore", restoreDeleted)
+}
+
+// deleteShipments performs the delete-command on shipments matching the filter
+// in Event-data. The affected shipments are returned, up to MaxReturned.
+func deleteShipments(
+	collection Collection, event *model.Event, source string, mode deleteMode,
+) *model.KafkaResponse {
 	filter := map[string]interface{}{}
 
 	err := json.Unmarshal(event.Data, &filter)
 	if err != nil {
-		err = errors.Wrap(err, "Delete: Error while unmarshalling Event-data")
+		err = errors.Wrap(err, source+": Error while unmarshalling Event-data")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
@@ -30,9 +69,29 @@ func Delete(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		}
 	}
 
+	flags := &commandFlags{}
+	err = json.Unmarshal(event.Data, flags)
+	if err != nil {
+		err = errors.Wrap(err, source+": Error while unmarshalling Command-flags")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	// Flags and service-action are specified alongside the filter,
+	// and are not part of it
+	for _, field := range flagFields {
+		delete(filter, field)
+	}
+	delete(filter, "serviceAction")
+
 	if len(filter) == 0 {
 		err = errors.New("blank filter provided")
-		err = errors.Wrap(err, "Delete")
+		err = errors.Wrap(err, source)
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
@@ -43,9 +102,97 @@ func Delete(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		}
 	}
 
-	deleteStats, err := collection.DeleteMany(filter)
+	verrs := checkFilter(filter)
+	verrs = append(verrs, checkPreconditions(flags.Preconditions)...)
+	if len(verrs) > 0 {
+		err = errors.Wrap(verrs, source)
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	switch mode {
+	case softDelete:
+		filter = notDeleted(filter)
+	case restoreDeleted:
+		filter = onlyDeleted(filter)
+	}
+
+	affected, err := flags.findAffected(collection, filter)
+	if err != nil {
+		err = errors.Wrap(err, source+": Error finding affected shipments")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if flags.DryRun {
+		return flags.dryRunResponse(source, event, collection, filter, affected)
+	}
+	err = flags.checkLimit(len(affected))
+	if err != nil {
+		err = errors.Wrap(err, source)
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     LimitExceededError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	filter = limitFilter(filter, affected)
+
+	preconditions := flags.Preconditions
+	if len(preconditions) > 0 {
+		failed, err := findFailedPreconditions(collection, filter, preconditions)
+		if err != nil {
+			err = errors.Wrap(err, source)
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		if len(failed) > 0 {
+			return preconditionResponse(source, event, &preconditionResult{
+				Failed: preconditionFailures(failed, preconditions),
+			})
+		}
+	}
+
+	var update map[string]interface{}
+	switch mode {
+	case softDelete:
+		update = map[string]interface{}{
+			"deletedAt": deletedAt(event),
+			"deletedBy": event.UserUUID.String(),
+		}
+	case restoreDeleted:
+		// UpdateMany sets the fields, so these are reset instead of being unset
+		update = map[string]interface{}{
+			"deletedAt": 0,
+			"deletedBy": (uuuid.UUID{}).String(),
+		}
+	}
+	// Shipments are written separately, so the shipments which are written
+	// and which fail the preconditions are known. Purged shipments are
+	// removed, using the nil update.
+	written, err := writeShipments(collection, filter, preconditions, affected, update)
 	if err != nil {
-		err = errors.Wrap(err, "Delete: Error in DeleteMany")
+		err = errors.Wrap(err, source+": Error writing shipments")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
@@ -56,10 +203,42 @@ func Delete(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		}
 	}
 
-	result := &deleteResult{deleteStats.DeletedCount}
+	// The written shipments stay written even if others fail, so their
+	// DomainEvents are published either way. Errors in publishing are only
+	// logged, since the shipments were written.
+	err = publish(event, written.Changes)
+	if err != nil {
+		err = errors.Wrap(err, source)
+		log.Println(err)
+	}
+
+	// Shipments modified after the preconditions were checked are not written
+	if len(written.Failed) > 0 {
+		return preconditionResponse(source, event, &preconditionResult{
+			MatchedCount:  int64(len(written.Changes)),
+			ModifiedCount: written.ModifiedCount,
+			Failed:        preconditionFailures(written.Failed, preconditions),
+		})
+	}
+
+	result := &deleteResult{}
+	ships := written.written()
+	switch mode {
+	case softDelete:
+		result.DeletedCount = written.ModifiedCount
+	case purgeDelete:
+		result.DeletedCount = written.ModifiedCount
+		for i, change := range written.Changes {
+			ships[i] = change.Before
+		}
+	case restoreDeleted:
+		result.RestoredCount = written.ModifiedCount
+	}
+	result.Shipments, result.Truncated = capReturned(ships)
+
 	resultMarshal, err := json.Marshal(result)
 	if err != nil {
-		err = errors.Wrap(err, "Delete: Error marshalling shipment Delete-result")
+		err = errors.Wrap(err, source+": Error marshalling shipment Delete-result")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
@@ -77,3 +256,42 @@ func Delete(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		UUID:          event.TimeUUID,
 	}
 }
+
+// deletedAt returns the deletedAt of shipments soft-deleted by the Event.
+// Events without Timestamp use the current time instead, since restoring
+// only matches a positive deletedAt.
+func deletedAt(event *model.Event) int64 {
+	if event.Timestamp.IsZero() {
+		return time.Now().Unix()
+	}
+	return event.Timestamp.Unix()
+}
+
+// notDeleted restricts the filter to shipments which are not soft-deleted.
+// Restored shipments have a zero deletedAt, since fields cannot be unset.
+func notDeleted(filter map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"deletedAt": map[string]interface{}{
+					"$in": []interface{}{nil, 0},
+				},
+			},
+		},
+	}
+}
+
+// onlyDeleted restricts the filter to soft-deleted shipments.
+func onlyDeleted(filter map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"deletedAt": map[string]interface{}{
+					"$gt": 0,
+				},
+			},
+		},
+	}
+}
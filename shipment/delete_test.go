@@ -0,0 +1,74 @@
+package shipment
+
+import (
+	"time"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("soft delete", func() {
+	It("should not treat service-action as purge filter", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"serviceAction":"purge"}`))
+		kr := Handle(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("Purge: blank filter provided"))
+	})
+
+	It("should not treat service-action as restore filter", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"serviceAction":"restore","dryRun":true}`))
+		kr := Handle(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("Restore: blank filter provided"))
+	})
+
+	It("should reject deletedAt in update", func() {
+		mockEvent := newMockEvent("update", []byte(`{"filter":{"lot":"A1"},"update":{"deletedAt":0}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("update.deletedAt: field is only set by delete events"))
+	})
+
+	It("should reject deletedBy in insert", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"itemID":"d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e","deletedBy":"d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1f"}`))
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should exclude soft-deleted shipments from filter", func() {
+		filter := map[string]interface{}{"lot": "A1"}
+		Expect(notDeleted(filter)).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				filter,
+				map[string]interface{}{
+					"deletedAt": map[string]interface{}{
+						"$in": []interface{}{nil, 0},
+					},
+				},
+			},
+		}))
+	})
+
+	It("should soft-delete with the current time if the Event has no Timestamp", func() {
+		Expect(deletedAt(&model.Event{})).To(BeNumerically(">", 0))
+		timestamp := time.Unix(1537904522, 0)
+		Expect(deletedAt(&model.Event{Timestamp: timestamp})).To(Equal(int64(1537904522)))
+	})
+})
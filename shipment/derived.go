@@ -0,0 +1,96 @@
+package shipment
+
+import (
+	"math"
+	"time"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+)
+
+// derivedFields are the stored fields computed from other Shipment fields.
+// These are written along with the fields they are computed from, and cannot
+// be set by events.
+var derivedFields = []string{"daysUntilExpiry", "margin", "remainingWeight", "sellThrough"}
+
+// derivedSources are the Shipment fields which derivedFields are computed from.
+var derivedSources = []string{
+	"donateWeight", "expiryDate", "price", "salePrice",
+	"soldWeight", "totalWeight", "wasteWeight",
+}
+
+// isDerivedField checks if field is computed from other Shipment fields.
+func isDerivedField(field string) bool {
+	for _, f := range derivedFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// remainingWeight is the weight neither sold, donated nor wasted,
+// in canonical unit.
+func (i *Shipment) remainingWeight() float64 {
+	if i.TotalWeight == 0 {
+		return 0
+	}
+	return i.TotalWeight - i.SoldWeight - i.DonateWeight - i.WasteWeight
+}
+
+// margin is the difference between the SalePrice and Price. It is zero
+// unless both prices are set.
+func (i *Shipment) margin() money.Money {
+	if i.Price.Amount == 0 || i.SalePrice.Amount == 0 {
+		return money.Money{}
+	}
+	margin, err := i.SalePrice.Sub(i.Price)
+	if err != nil {
+		// Prices are validated to have the same currency
+		return money.Money{}
+	}
+	return margin
+}
+
+// sellThrough is the percent of TotalWeight which is sold.
+func (i *Shipment) sellThrough() float64 {
+	if i.TotalWeight == 0 {
+		return 0
+	}
+	return i.SoldWeight / i.TotalWeight * 100
+}
+
+// daysUntilExpiry is the number of whole days from now until the ExpiryDate,
+// which is negative for expired shipments. Since this changes over time, the
+// stored value is as of the shipment's last write.
+func (i *Shipment) daysUntilExpiry(now time.Time) int64 {
+	if i.ExpiryDate == 0 {
+		return 0
+	}
+	seconds := float64(i.ExpiryDate - now.Unix())
+	return int64(math.Floor(seconds / (24 * 60 * 60)))
+}
+
+// withDerived returns a copy of update, also setting the derivedFields as
+// computed from the shipment after the update.
+func withDerived(update map[string]interface{}, after *Shipment) map[string]interface{} {
+	derived := after.storedFields(derivedFields)
+	written := make(map[string]interface{}, len(update)+len(derived))
+	for field, value := range update {
+		written[field] = value
+	}
+	for field, value := range derived {
+		written[field] = value
+	}
+	return written
+}
+
+// derivedFilter is the filter matching the current values of the
+// derivedSources, so the derived fields computed from these are only written
+// if these were not modified.
+func derivedFilter(current *Shipment) map[string]interface{} {
+	filter := map[string]interface{}{}
+	for field, value := range current.storedFields(derivedSources) {
+		filter[field] = zeroMatch(value)
+	}
+	return filter
+}
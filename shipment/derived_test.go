@@ -0,0 +1,72 @@
+package shipment
+
+import (
+	"time"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("derived fields", func() {
+	It("should return ValidationError when inserting derived fields", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"itemID":"2b7d7c7e-5d28-4a5c-9d7a-1c2f8e7b6a11","lot":"A1","margin":"2.00"}`))
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("margin: is derived from other fields"))
+	})
+
+	It("should compute and store the derived fields", func() {
+		now := time.Now()
+		ship := &Shipment{
+			DonateWeight: 1,
+			ExpiryDate:   now.Add(3*24*time.Hour + time.Hour).Unix(),
+			Price:        money.FromMinor(1000, "USD"),
+			SalePrice:    money.FromMinor(1340, "USD"),
+			SoldWeight:   4,
+			TotalWeight:  10,
+			WasteWeight:  0.5,
+		}
+		Expect(ship.remainingWeight()).To(Equal(4.5))
+		Expect(ship.margin()).To(Equal(money.FromMinor(340, "USD")))
+		Expect(ship.sellThrough()).To(Equal(40.0))
+		Expect(ship.daysUntilExpiry(now)).To(Equal(int64(3)))
+
+		stored := ship.storedFields(derivedFields)
+		Expect(stored).To(Equal(map[string]interface{}{
+			"daysUntilExpiry": int64(3),
+			"margin":          int64(340),
+			"remainingWeight": 4.5,
+			"sellThrough":     40.0,
+		}))
+	})
+
+	It("should count expired shipments with negative days", func() {
+		now := time.Now()
+		ship := &Shipment{ExpiryDate: now.Add(-time.Hour).Unix()}
+		Expect(ship.daysUntilExpiry(now)).To(Equal(int64(-1)))
+	})
+
+	It("should leave derived fields blank without their source fields", func() {
+		ship := &Shipment{SalePrice: money.FromMinor(1340, "USD")}
+		Expect(ship.storedFields(derivedFields)).To(Equal(map[string]interface{}{
+			"daysUntilExpiry": int64(0),
+			"margin":          int64(0),
+			"remainingWeight": 0.0,
+			"sellThrough":     0.0,
+		}))
+	})
+
+	It("should reject updates to derived fields", func() {
+		verrs := checkUpdatePolicy(map[string]interface{}{
+			"sellThrough": 50,
+		})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"update.sellThrough", "field is derived from other fields"},
+		}))
+	})
+})
@@ -6,3 +6,19 @@ const InternalError = 2
 // DatabaseError is when some operation related to Database, such as insert or find,
 // goes wrong and the task cannot proceed.
 const DatabaseError = 3
+
+// InvalidStateError is when the operation is not allowed in the current state
+// of the Aggregate, such as selling a recalled shipment.
+const InvalidStateError = 4
+
+// ValidationError is when the provided data is invalid, such as a Barcode
+// with incorrect check-digit.
+const ValidationError = 5
+
+// LimitExceededError is when the command would affect more shipments than allowed,
+// such as a delete with an overly broad filter.
+const LimitExceededError = 6
+
+// PreconditionFailedError is when the shipments do not satisfy the preconditions
+// of a command, such as having an expected Status.
+const PreconditionFailedError = 7
@@ -0,0 +1,159 @@
+package shipment
+
+import (
+	"reflect"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+// Types of DomainEvents.
+const (
+	ShipmentCreated  = "ShipmentCreated"
+	ShipmentUpdated  = "ShipmentUpdated"
+	ShipmentDeleted  = "ShipmentDeleted"
+	ShipmentRestored = "ShipmentRestored"
+	ShipmentPurged   = "ShipmentPurged"
+	ShipmentSold     = "ShipmentSold"
+	ShipmentDonated  = "ShipmentDonated"
+	ShipmentWasted   = "ShipmentWasted"
+	ShipmentHeld     = "ShipmentHeld"
+	ShipmentReleased = "ShipmentReleased"
+	ShipmentRecalled = "ShipmentRecalled"
+)
+
+// DomainEvent describes a change made to a shipment by a successful command.
+type DomainEvent struct {
+	EventID     uuuid.UUID `json:"eventID"`
+	Type        string     `json:"type"`
+	AggregateID int8       `json:"aggregateID"`
+	ItemID      uuuid.UUID `json:"itemID"`
+	// Before is the shipment before the change. It is nil for created shipments.
+	Before *Shipment `json:"before,omitempty"`
+	// After is the shipment after the change. It is nil for purged shipments.
+	After *Shipment `json:"after,omitempty"`
+	// CorrelationID is the CorrelationID of the Event which caused the change,
+	// and CausationID is its TimeUUID.
+	CorrelationID uuuid.UUID `json:"correlationID"`
+	CausationID   uuuid.UUID `json:"causationID"`
+	UserUUID      uuuid.UUID `json:"userUUID"`
+	Timestamp     int64      `json:"timestamp"`
+}
+
+// PublishEvents publishes the DomainEvents of successful commands, such as
+// to Kafka. DomainEvents are not created if it is nil.
+var PublishEvents func(events []*DomainEvent) error
+
+// shipmentChange is the state of a shipment before and after a change.
+type shipmentChange struct {
+	Before *Shipment
+	After  *Shipment
+}
+
+// changeTypes returns the types of DomainEvents for the change. Changes which
+// are not domain-specific, such as corrections of names, are ShipmentUpdated.
+// Every change has at least one type.
+func changeTypes(change shipmentChange) []string {
+	before, after := change.Before, change.After
+	switch {
+	case before == nil:
+		return []string{ShipmentCreated}
+	case after == nil:
+		return []string{ShipmentPurged}
+	case before.DeletedAt == 0 && after.DeletedAt != 0:
+		return []string{ShipmentDeleted}
+	case before.DeletedAt != 0 && after.DeletedAt == 0:
+		return []string{ShipmentRestored}
+	}
+
+	types := []string{}
+	if after.SoldWeight > before.SoldWeight {
+		types = append(types, ShipmentSold)
+	}
+	if after.DonateWeight > before.DonateWeight {
+		types = append(types, ShipmentDonated)
+	}
+	if after.WasteWeight > before.WasteWeight {
+		types = append(types, ShipmentWasted)
+	}
+	if after.Status != before.Status {
+		switch after.Status {
+		case StatusHeld:
+			types = append(types, ShipmentHeld)
+		case StatusRecalled:
+			types = append(types, ShipmentRecalled)
+		case StatusAvailable, "":
+			types = append(types, ShipmentReleased)
+		}
+	}
+	if len(types) == 0 {
+		types = append(types, ShipmentUpdated)
+	}
+	return types
+}
+
+// domainEvents creates the DomainEvents for changes caused by the Event.
+// Shipments which are unchanged have no DomainEvents.
+func domainEvents(event *model.Event, changes []shipmentChange) ([]*DomainEvent, error) {
+	events := []*DomainEvent{}
+	for _, change := range changes {
+		if reflect.DeepEqual(change.Before, change.After) {
+			continue
+		}
+		ship := change.After
+		if ship == nil {
+			ship = change.Before
+		}
+		for _, eventType := range changeTypes(change) {
+			eventID, err := uuuid.NewV4()
+			if err != nil {
+				err = errors.Wrap(err, "Error generating EventID")
+				return nil, err
+			}
+			events = append(events, &DomainEvent{
+				EventID:       eventID,
+				Type:          eventType,
+				AggregateID:   AggregateID,
+				ItemID:        ship.ItemID,
+				Before:        change.Before,
+				After:         change.After,
+				CorrelationID: event.CorrelationID,
+				CausationID:   event.TimeUUID,
+				UserUUID:      event.UserUUID,
+				Timestamp:     event.Timestamp.Unix(),
+			})
+		}
+	}
+	return events, nil
+}
+
+// publish publishes the DomainEvents for changes caused by the Event.
+func publish(event *model.Event, changes []shipmentChange) error {
+	if PublishEvents == nil {
+		return nil
+	}
+	events, err := domainEvents(event, changes)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating DomainEvents")
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	err = PublishEvents(events)
+	if err != nil {
+		err = errors.Wrap(err, "Error publishing DomainEvents")
+		return err
+	}
+	return nil
+}
+
+// publishCreated publishes ShipmentCreated for the inserted shipments.
+func publishCreated(event *model.Event, ships []*Shipment) error {
+	changes := make([]shipmentChange, len(ships))
+	for i, ship := range ships {
+		changes[i] = shipmentChange{After: ship}
+	}
+	return publish(event, changes)
+}
@@ -0,0 +1,311 @@
+package shipment
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("publishing DomainEvents", func() {
+	var (
+		first     *Shipment
+		second    *Shipment
+		mockEvent *model.Event
+		published []*DomainEvent
+	)
+
+	// updateEvent creates the Event updating the lot of shipments from farm.
+	updateEvent := func(preconditions map[string]interface{}) *model.Event {
+		return newMockEvent("update", map[string]interface{}{
+			"filter":        map[string]interface{}{"origin": "farm"},
+			"update":        map[string]interface{}{"lot": "B2"},
+			"preconditions": preconditions,
+		})
+	}
+
+	// sequenceCollection returns the found shipments in order for each Find,
+	// and writes only the first shipment.
+	sequenceCollection := func(finds ...[]interface{}) *fakeCollection {
+		findCount := 0
+		writeCount := 0
+		return &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				findCount++
+				if findCount > len(finds) {
+					return []interface{}{}, nil
+				}
+				return finds[findCount-1], nil
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				writeCount++
+				if writeCount == 1 {
+					return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+				}
+				return &mgo.UpdateResult{}, nil
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		first = &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			Origin:      "farm",
+			TotalWeight: 10,
+		}
+		itemID, err = uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		second = &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			Origin:      "farm",
+			TotalWeight: 10,
+		}
+
+		published = []*DomainEvent{}
+		PublishEvents = func(events []*DomainEvent) error {
+			published = append(published, events...)
+			return nil
+		}
+	})
+
+	AfterEach(func() {
+		PublishEvents = nil
+	})
+
+	It("should publish only the shipments written by the command", func() {
+		// The second shipment no longer matches the filter when written
+		collection := sequenceCollection([]interface{}{first, second})
+		mockEvent = updateEvent(nil)
+
+		kr := Update(collection, mockEvent)
+		Expect(kr.Error).To(BeEmpty())
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Type).To(Equal(ShipmentUpdated))
+		Expect(published[0].ItemID).To(Equal(first.ItemID))
+		Expect(published[0].CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(published[0].Before).To(Equal(first))
+		// The after state is the written update, and is not found again
+		Expect(published[0].After.Lot).To(Equal("B2"))
+		Expect(published[0].After.Origin).To(Equal("farm"))
+	})
+
+	It("should publish the written shipments if others fail preconditions", func() {
+		// The second shipment fails the preconditions when written
+		modified := *second
+		modified.Lot = "C3"
+		collection := sequenceCollection(
+			[]interface{}{first, second},
+			[]interface{}{},
+			[]interface{}{&modified},
+			[]interface{}{},
+		)
+		mockEvent = updateEvent(map[string]interface{}{"lot": "A1"})
+
+		kr := Update(collection, mockEvent)
+		Expect(kr.ErrorCode).To(Equal(int16(PreconditionFailedError)))
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].ItemID).To(Equal(first.ItemID))
+		Expect(published[0].After.Lot).To(Equal("B2"))
+	})
+
+	It("should relay the events of written shipments with the precondition failure", func() {
+		modified := *second
+		modified.Lot = "C3"
+		collection := sequenceCollection(
+			[]interface{}{first, second},
+			[]interface{}{},
+			[]interface{}{&modified},
+			[]interface{}{},
+		)
+		mockEvent = updateEvent(map[string]interface{}{"lot": "A1"})
+
+		// The Outbox stores the events collected while handling the Event in
+		// its entry, from which these are relayed along with the response
+		outbox := &Outbox{}
+		PublishEvents = outbox.AddEvents
+		outbox.startEvents(mockEvent.TimeUUID.String())
+		kr := Update(collection, mockEvent)
+		entry, err := newOutboxEntry(
+			mockEvent, kr, outbox.takeEvents(mockEvent.TimeUUID.String()),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp, events, err := entry.Messages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.ErrorCode).To(Equal(int16(PreconditionFailedError)))
+		result := &preconditionResult{}
+		err = json.Unmarshal(resp.Result, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.MatchedCount).To(Equal(int64(1)))
+		Expect(result.Failed).To(HaveLen(1))
+
+		Expect(events).To(HaveLen(1))
+		event := &DomainEvent{}
+		err = json.Unmarshal(events[0], event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Type).To(Equal(ShipmentUpdated))
+		Expect(event.ItemID).To(Equal(first.ItemID))
+		Expect(event.CausationID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should not publish if the command fails preconditions before writing", func() {
+		collection := sequenceCollection(
+			[]interface{}{first, second},
+			[]interface{}{second},
+		)
+		mockEvent = updateEvent(map[string]interface{}{"lot": "C3"})
+
+		kr := Update(collection, mockEvent)
+		Expect(kr.ErrorCode).To(Equal(int16(PreconditionFailedError)))
+		Expect(published).To(BeEmpty())
+	})
+
+	It("should publish the merged shipment of upserts", func() {
+		collection := sequenceCollection([]interface{}{first})
+		data, err := json.Marshal(map[string]interface{}{
+			"itemID": first.ItemID.String(),
+			"lot":    "B2",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		mockEvent = updateEvent(nil)
+		mockEvent.Action = "upsert"
+		mockEvent.Data = data
+
+		kr := Upsert(collection, mockEvent)
+		Expect(kr.Error).To(BeEmpty())
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Before).To(Equal(first))
+		Expect(published[0].After.Lot).To(Equal("B2"))
+	})
+})
+
+var _ = Describe("DomainEvents", func() {
+	var mockEvent *model.Event
+
+	BeforeEach(func() {
+		mockEvent = newMockEvent("update", []byte{})
+	})
+
+	AfterEach(func() {
+		PublishEvents = nil
+	})
+
+	It("should type changes by their domain-specific changes", func() {
+		before := &Shipment{
+			SoldWeight:  1,
+			Status:      StatusHeld,
+			TotalWeight: 10,
+			WasteWeight: 1,
+		}
+		changed := func(change func(ship *Shipment)) shipmentChange {
+			after := *before
+			change(&after)
+			return shipmentChange{Before: before, After: &after}
+		}
+
+		Expect(changeTypes(shipmentChange{After: before})).To(Equal([]string{ShipmentCreated}))
+		Expect(changeTypes(shipmentChange{Before: before})).To(Equal([]string{ShipmentPurged}))
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.DeletedAt = 1537904522
+			ship.SoldWeight = 2
+		}))).To(Equal([]string{ShipmentDeleted}))
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.Name = "renamed"
+		}))).To(Equal([]string{ShipmentUpdated}))
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.SoldWeight = 2
+			ship.WasteWeight = 2
+		}))).To(Equal([]string{ShipmentSold, ShipmentWasted}))
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.DonateWeight = 1
+			ship.Status = StatusAvailable
+		}))).To(Equal([]string{ShipmentDonated, ShipmentReleased}))
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.Status = StatusRecalled
+		}))).To(Equal([]string{ShipmentRecalled}))
+
+		before.DeletedAt = 1537904522
+		Expect(changeTypes(changed(func(ship *Shipment) {
+			ship.DeletedAt = 0
+		}))).To(Equal([]string{ShipmentRestored}))
+	})
+
+	It("should create events carrying the change and the causing Event", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		before := &Shipment{ItemID: itemID, TotalWeight: 10}
+		after := &Shipment{ItemID: itemID, TotalWeight: 10, SoldWeight: 2}
+
+		events, err := domainEvents(mockEvent, []shipmentChange{
+			shipmentChange{Before: before, After: after},
+			// Unchanged shipments have no events
+			shipmentChange{Before: before, After: before},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+
+		e := events[0]
+		Expect(e.EventID).ToNot(Equal(uuuid.UUID{}))
+		Expect(e.Type).To(Equal(ShipmentSold))
+		Expect(e.AggregateID).To(Equal(AggregateID))
+		Expect(e.ItemID).To(Equal(itemID))
+		Expect(e.Before).To(Equal(before))
+		Expect(e.After).To(Equal(after))
+		Expect(e.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(e.CausationID).To(Equal(mockEvent.TimeUUID))
+		Expect(e.UserUUID).To(Equal(mockEvent.UserUUID))
+		Expect(e.Timestamp).To(Equal(mockEvent.Timestamp.Unix()))
+	})
+
+	It("should publish created shipments", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		ship := &Shipment{ItemID: itemID}
+
+		// Nothing is published without a publisher
+		err = publishCreated(mockEvent, []*Shipment{ship})
+		Expect(err).ToNot(HaveOccurred())
+
+		published := []*DomainEvent{}
+		PublishEvents = func(events []*DomainEvent) error {
+			published = append(published, events...)
+			return nil
+		}
+		err = publishCreated(mockEvent, []*Shipment{ship})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Type).To(Equal(ShipmentCreated))
+		Expect(published[0].Before).To(BeNil())
+		Expect(published[0].After).To(Equal(ship))
+
+		marshalEvent, err := json.Marshal(published[0])
+		Expect(err).ToNot(HaveOccurred())
+		m := map[string]interface{}{}
+		err = json.Unmarshal(marshalEvent, &m)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m["type"]).To(Equal(ShipmentCreated))
+		Expect(m["correlationID"]).To(Equal(mockEvent.CorrelationID.String()))
+		Expect(m).ToNot(HaveKey("before"))
+		Expect(m["after"]).To(HaveKeyWithValue("itemID", itemID.String()))
+	})
+
+	It("should return publishing errors", func() {
+		PublishEvents = func(events []*DomainEvent) error {
+			return errors.New("broker unavailable")
+		}
+		err := publishCreated(mockEvent, []*Shipment{&Shipment{}})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("broker unavailable"))
+	})
+})
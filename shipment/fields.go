@@ -0,0 +1,135 @@
+package shipment
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// StrictFields rejects unknown fields in insert and update events when true.
+// Otherwise, unknown fields are dropped from inserts. Updates of unknown fields
+// are always rejected, since these would be written as-is.
+var StrictFields = true
+
+// knownFields are the JSON field-names of Shipment, as stored in Mongo.
+var knownFields = jsonFields(storedType)
+
+// insertFields are the non-Shipment fields accepted in insert events.
+var insertFields = []string{"digitalLink", "gs1", "serviceAction"}
+
+// isKnownField checks if field is a Shipment field.
+func isKnownField(field string) bool {
+	for _, f := range knownFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// isZero checks if value is the zero-value of its type.
+func isZero(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	return reflect.DeepEqual(value, reflect.Zero(reflect.TypeOf(value)).Interface())
+}
+
+// jsonFields returns the sorted JSON field-names of struct-type t.
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// checkFields returns errors for keys in m which are neither Shipment fields
+// nor any of the extra fields. Each error suggests the closest known field.
+// Nothing is checked if StrictFields is disabled.
+func checkFields(m map[string]interface{}, extra ...string) ValidationErrors {
+	if !StrictFields {
+		return nil
+	}
+
+	allowed := append(append([]string{}, knownFields...), extra...)
+	isAllowed := map[string]bool{}
+	for _, field := range allowed {
+		isAllowed[field] = true
+	}
+
+	unknown := []string{}
+	for _, key := range sortedKeys(m) {
+		// Attribute paths are checked against AttributeSchema
+		if !isAllowed[key] && !isAttributePath(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	verrs := ValidationErrors{}
+	for _, key := range unknown {
+		verrs = append(verrs, FieldError{key, unknownFieldMsg(key, allowed)})
+	}
+	return verrs
+}
+
+// unknownFieldMsg is the error for the unknown field key, naming the closest
+// of the allowed fields.
+func unknownFieldMsg(key string, allowed []string) string {
+	suggestion := closestField(key, allowed)
+	if suggestion != "" {
+		return fmt.Sprintf("unknown field, did you mean %s?", suggestion)
+	}
+	return "unknown field"
+}
+
+// closestField returns the field with least edit-distance from key, or blank
+// if no field is close enough to be a likely typo.
+func closestField(key string, fields []string) string {
+	closest := ""
+	minDist := len(key)/2 + 1
+	for _, field := range fields {
+		dist := editDistance(strings.ToLower(key), strings.ToLower(field))
+		if dist < minDist {
+			closest = field
+			minDist = dist
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,67 @@
+package shipment
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("strict fields", func() {
+	It("should reject unknown fields in insert, suggesting the closest field", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"itemID":"d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e","expiryDte":1540000000}`))
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("expiryDte: unknown field, did you mean expiryDate?"))
+	})
+
+	It("should reject unknown fields in update, suggesting the closest field", func() {
+		mockEvent := newMockEvent("update", []byte(`{"filter":{"lot":"A1"},"update":{"Lot":"B2","zzzzzz":1}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("Lot: unknown field, did you mean lot?"))
+		Expect(kr.Error).To(ContainSubstring("zzzzzz: unknown field"))
+		Expect(kr.Error).ToNot(ContainSubstring("zzzzzz: unknown field, did you mean"))
+	})
+
+	It("should allow unknown fields if StrictFields is disabled", func() {
+		StrictFields = false
+		defer func() {
+			StrictFields = true
+		}()
+		verrs := checkFields(map[string]interface{}{
+			"expiryDte": 1540000000,
+		})
+		Expect(verrs).To(BeNil())
+	})
+
+	It("should reject updates of unknown fields even if StrictFields is disabled", func() {
+		StrictFields = false
+		defer func() {
+			StrictFields = true
+		}()
+		verrs := checkUpdatePolicy(map[string]interface{}{
+			"attributes.organic": true,
+			"expiryDte":          1540000000,
+			"lot":                "A1",
+		})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"update.expiryDte", "unknown field, did you mean expiryDate?"},
+		}))
+	})
+
+	It("should allow Shipment and extra fields", func() {
+		verrs := checkFields(map[string]interface{}{
+			"currency":   "USD",
+			"expiryDate": 1540000000,
+			"gs1":        "(01)09501101530003",
+		}, insertFields...)
+		Expect(verrs).To(BeNil())
+	})
+})
@@ -0,0 +1,39 @@
+package shipment
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/gomega"
+)
+
+// newMockEvent creates an Event with the action, as received from the
+// event-store. Data is used as the Event-data if it is a byte-slice, and is
+// otherwise marshalled to JSON.
+func newMockEvent(action string, data interface{}) *model.Event {
+	timeUUID, err := uuuid.NewV1()
+	Expect(err).ToNot(HaveOccurred())
+	cid, err := uuuid.NewV4()
+	Expect(err).ToNot(HaveOccurred())
+	uid, err := uuuid.NewV4()
+	Expect(err).ToNot(HaveOccurred())
+
+	marshalData, isBytes := data.([]byte)
+	if !isBytes {
+		marshalData, err = json.Marshal(data)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	return &model.Event{
+		Action:        action,
+		CorrelationID: cid,
+		AggregateID:   6,
+		Data:          marshalData,
+		Timestamp:     time.Now(),
+		UserUUID:      uid,
+		TimeUUID:      timeUUID,
+		Version:       3,
+		YearBucket:    2018,
+	}
+}
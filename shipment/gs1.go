@@ -0,0 +1,83 @@
+package shipment
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/TerrexTech/agg-shipment-cmd/barcode"
+)
+
+// DigitalLinkDomain is the domain used for GS1 Digital Link URIs of shipments.
+var DigitalLinkDomain = "https://id.example"
+
+// weightTolerance is the maximum difference for weights to be considered equal.
+const weightTolerance = 1e-9
+
+// DigitalLink returns the GS1 Digital Link URI for shipment,
+// created from its Barcode, Lot and ExpiryDate.
+func (i *Shipment) DigitalLink() (string, error) {
+	gs1 := &barcode.GS1{
+		GTIN: i.Barcode,
+		Lot:  i.Lot,
+	}
+	if i.ExpiryDate != 0 {
+		gs1.Expiry = time.Unix(i.ExpiryDate, 0)
+	}
+	return barcode.FormatDigitalLink(DigitalLinkDomain, gs1)
+}
+
+// applyGS1 fills the Barcode, Lot, ExpiryDate and TotalWeight from the GS1 data
+// parsed from the specified source-field. Fields which were also specified
+// explicitly must match the GS1 data.
+func (i *Shipment) applyGS1(gs1 *barcode.GS1, source string) ValidationErrors {
+	verrs := ValidationErrors{}
+	conflict := func(field string, value interface{}) {
+		verrs = append(verrs, FieldError{
+			field,
+			fmt.Sprintf("conflicts with %s value: %v", source, value),
+		})
+	}
+
+	if gs1.GTIN != "" {
+		if i.Barcode == "" {
+			i.Barcode = gs1.GTIN
+		} else {
+			gtin, err := barcode.Normalize(i.Barcode)
+			// Invalid Barcodes are reported by Barcode-validation instead
+			if err == nil && gtin != gs1.GTIN {
+				conflict("barcode", gs1.GTIN)
+			}
+		}
+	}
+	if gs1.Lot != "" {
+		if i.Lot == "" {
+			i.Lot = gs1.Lot
+		} else if i.Lot != gs1.Lot {
+			conflict("lot", gs1.Lot)
+		}
+	}
+	if !gs1.Expiry.IsZero() {
+		if i.ExpiryDate == 0 {
+			i.ExpiryDate = gs1.Expiry.Unix()
+		} else {
+			// GS1 expiry only has date-precision
+			expiry := time.Unix(i.ExpiryDate, 0).UTC().Format("2006-01-02")
+			if expiry != gs1.Expiry.Format("2006-01-02") {
+				conflict("expiryDate", gs1.Expiry.Format("2006-01-02"))
+			}
+		}
+	}
+	if gs1.NetWeight != 0 {
+		if i.TotalWeight == 0 {
+			i.TotalWeight = gs1.NetWeight
+		} else if math.Abs(i.TotalWeight-gs1.NetWeight) > weightTolerance {
+			conflict("totalWeight", gs1.NetWeight)
+		}
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
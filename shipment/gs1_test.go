@@ -0,0 +1,63 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gs1", func() {
+	It("should return error if gs1 conflicts with explicit fields", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		insertArgs := map[string]interface{}{
+			"itemID": itemID.String(),
+			"lot":    "test-lot",
+			"gs1":    "(01)09506000134352(10)ABC123",
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("lot"))
+	})
+
+	It("should return error if gs1 is malformed", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		insertArgs := map[string]interface{}{
+			"itemID": itemID.String(),
+			"gs1":    "(01)0950600013435",
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+})
+
+var _ = Describe("digitalLink", func() {
+	It("should return error if digitalLink conflicts with explicit fields", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		insertArgs := map[string]interface{}{
+			"itemID":      itemID.String(),
+			"barcode":     "036000291452",
+			"digitalLink": "https://id.example/01/09506000134352",
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("barcode"))
+	})
+})
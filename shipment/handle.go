@@ -0,0 +1,123 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/pkg/errors"
+)
+
+// Handler handles an Event for Shipment Aggregate and returns the
+// KafkaResponse to be produced for it.
+type Handler func(collection Collection, event *model.Event) *model.KafkaResponse
+
+// actionHandlers are the default Handlers for Event-Actions.
+var actionHandlers = map[string]Handler{
+	"delete": Delete,
+	"insert": Insert,
+	"update": Update,
+}
+
+// serviceActions are the Shipment-specific actions, keyed by the Event-Action
+// that carries them. EventPoll only routes insert, update and delete events,
+// so these actions are specified using the "serviceAction" key in Event-data.
+var serviceActions = map[string]map[string]Handler{
+	"delete": map[string]Handler{
+		"purge":   Purge,
+		"restore": Restore,
+	},
+	"insert": map[string]Handler{
+		"upsert": Upsert,
+	},
+	"update": map[string]Handler{
+		"hold":    Hold,
+		"recall":  Recall,
+		"release": Release,
+	},
+}
+
+type serviceAction struct {
+	ServiceAction string `json:"serviceAction"`
+}
+
+// Handle routes the Event to the Handler for its Event-Action, or to the
+// Handler for its service-action if one is specified in Event-data.
+// Event-data is first upcasted from the Event's Version using the
+// registered Upcasters.
+// Panics in Handlers are recovered and returned as errors, so a single
+// malformed Event cannot take down the service.
+func Handle(
+	collection Collection, event *model.Event,
+) (kafkaResp *model.KafkaResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := errors.Errorf("recovered from panic: %v", r)
+			err = errors.Wrap(err, "Handle")
+			log.Println(err)
+			kafkaResp = &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     InternalError,
+				UUID:          event.TimeUUID,
+			}
+		}
+	}()
+
+	handler := actionHandlers[event.Action]
+	if handler == nil {
+		err := errors.Errorf("invalid Event-Action: %s", event.Action)
+		err = errors.Wrap(err, "Handle")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	// Older payloads are converted to the current shape before being routed,
+	// so Handlers only deal with the current Event-data
+	upcasted, err := upcast(event)
+	if err != nil {
+		err = errors.Wrap(err, "Handle")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	event = upcasted
+
+	// Errors are ignored here since Event-data might not be an object,
+	// in which case the Event-Action's Handler will report them.
+	sa := &serviceAction{}
+	json.Unmarshal(event.Data, sa)
+	if sa.ServiceAction == "" {
+		return handler(collection, event)
+	}
+
+	handler = serviceActions[event.Action][sa.ServiceAction]
+	if handler == nil {
+		err := errors.Errorf(
+			"invalid service-action: %s for Event-Action: %s",
+			sa.ServiceAction, event.Action,
+		)
+		err = errors.Wrap(err, "Handle")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	return handler(collection, event)
+}
@@ -0,0 +1,41 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/go-eventstore-models/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handle", func() {
+	It("should route service-actions to their handlers", func() {
+		recallArgs := map[string]interface{}{
+			"serviceAction": "recall",
+		}
+		mockEvent := newMockEvent("update", recallArgs)
+		kr := Handle(nil, mockEvent)
+		Expect(kr.Error).To(ContainSubstring("Recall"))
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+	})
+
+	It("should recover from panics in handlers", func() {
+		mockEvent := newMockEvent("update", []byte(`{"serviceAction": "recall", "lot": "x", "recallRef": "y"}`))
+		// Nil collection causes a panic when accessing Mongo
+		var kr *model.KafkaResponse
+		Expect(func() {
+			kr = Handle(nil, mockEvent)
+		}).ToNot(Panic())
+		Expect(kr.Error).To(ContainSubstring("panic"))
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should return error if service-action is invalid", func() {
+		mockEvent := newMockEvent("insert", []byte(`{"serviceAction": "recall"}`))
+		kr := Handle(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+})
@@ -0,0 +1,305 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+type holdArgs struct {
+	ItemID uuuid.UUID `json:"itemID,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// findShipment finds the shipment with specified ItemID.
+// Nil is returned if no such shipment exists.
+func findShipment(collection Collection, itemID uuuid.UUID) (*Shipment, error) {
+	findResults, err := collection.Find(notDeleted(map[string]interface{}{
+		"itemID": itemID.String(),
+	}))
+	if err != nil {
+		err = errors.Wrap(err, "Error in Find")
+		return nil, err
+	}
+	if len(findResults) == 0 {
+		return nil, nil
+	}
+	ship, assertOK := findResults[0].(*Shipment)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Shipment")
+		return nil, err
+	}
+	return ship, nil
+}
+
+// Hold handles "hold" service-action. It places the shipment on hold, recording
+// the user who placed the hold and the reason for it. Shipments on hold cannot be
+// sold or donated until released.
+func Hold(collection Collection, event *model.Event) *model.KafkaResponse {
+	args := &holdArgs{}
+	err := json.Unmarshal(event.Data, args)
+	if err != nil {
+		err = errors.Wrap(err, "Hold: Error while unmarshalling Event-data")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	if args.ItemID == (uuuid.UUID{}) {
+		err = errors.New("missing ItemID")
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if args.Reason == "" {
+		err = errors.New("missing Reason")
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	ship, err := findShipment(collection, args.ItemID)
+	if err != nil {
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if ship == nil {
+		err = errors.Errorf("shipment with ItemID %s not found", args.ItemID)
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if ship.Status == StatusHeld || ship.Status == StatusRecalled {
+		err = errors.Errorf("cannot hold shipment with Status: %s", ship.Status)
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InvalidStateError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	written, err := writeShipments(
+		collection,
+		notDeleted(map[string]interface{}{
+			"itemID": args.ItemID.String(),
+			"status": map[string]interface{}{
+				"$nin": []string{StatusHeld, StatusRecalled},
+			},
+		}),
+		nil,
+		[]*Shipment{ship},
+		map[string]interface{}{
+			"dateHeld":   event.Timestamp.Unix(),
+			"holdBy":     event.UserUUID.String(),
+			"holdReason": args.Reason,
+			"status":     StatusHeld,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Hold: Error writing shipment")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	// The shipment was held or recalled after it was checked above
+	if len(written.Changes) == 0 {
+		err = errors.New("shipment Status changed while placing hold")
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InvalidStateError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	// The command succeeded, so errors in publishing are only logged
+	err = publish(event, written.Changes)
+	if err != nil {
+		err = errors.Wrap(err, "Hold")
+		log.Println(err)
+	}
+
+	result := &updateResult{
+		MatchedCount:  int64(len(written.Changes)),
+		ModifiedCount: written.ModifiedCount,
+	}
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Hold: Error marshalling Shipment Hold-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
+
+// Release handles "release" service-action. It releases the hold on shipment,
+// making it available again. The details of the released hold are retained.
+func Release(collection Collection, event *model.Event) *model.KafkaResponse {
+	args := &holdArgs{}
+	err := json.Unmarshal(event.Data, args)
+	if err != nil {
+		err = errors.Wrap(err, "Release: Error while unmarshalling Event-data")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	if args.ItemID == (uuuid.UUID{}) {
+		err = errors.New("missing ItemID")
+		err = errors.Wrap(err, "Release")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	// The shipment is found so its change is known, and is checked to be held
+	// when it is written
+	ship, err := findShipment(collection, args.ItemID)
+	if err != nil {
+		err = errors.Wrap(err, "Release")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	ships := []*Shipment{}
+	if ship != nil {
+		ships = append(ships, ship)
+	}
+	written, err := writeShipments(
+		collection,
+		notDeleted(map[string]interface{}{
+			"itemID": args.ItemID.String(),
+			"status": StatusHeld,
+		}),
+		nil,
+		ships,
+		map[string]interface{}{
+			"status": StatusAvailable,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Release: Error writing shipment")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if len(written.Changes) == 0 {
+		err = errors.Errorf("no held shipment found with ItemID %s", args.ItemID)
+		err = errors.Wrap(err, "Release")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InvalidStateError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	// The command succeeded, so errors in publishing are only logged
+	err = publish(event, written.Changes)
+	if err != nil {
+		err = errors.Wrap(err, "Release")
+		log.Println(err)
+	}
+
+	result := &updateResult{
+		MatchedCount:  int64(len(written.Changes)),
+		ModifiedCount: written.ModifiedCount,
+	}
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Release: Error marshalling Shipment Release-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
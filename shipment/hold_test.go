@@ -0,0 +1,47 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hold", func() {
+	It("should return error if itemID is empty", func() {
+		mockEvent := newMockEvent("update", []byte(`{"serviceAction": "hold", "reason": "test-reason"}`))
+		kr := Hold(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should return error if reason is empty", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		holdArgs := map[string]interface{}{
+			"serviceAction": "hold",
+			"itemID":        itemID.String(),
+		}
+		mockEvent := newMockEvent("update", holdArgs)
+		kr := Hold(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+})
+
+var _ = Describe("release", func() {
+	It("should return error if itemID is empty", func() {
+		mockEvent := newMockEvent("update", []byte(`{"serviceAction": "release"}`))
+		kr := Release(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+})
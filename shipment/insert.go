@@ -4,38 +4,153 @@ import (
 	"encoding/json"
 	"log"
 
+	"github.com/TerrexTech/agg-shipment-cmd/barcode"
 	"github.com/TerrexTech/go-eventstore-models/model"
-	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/TerrexTech/uuuid"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/pkg/errors"
 )
 
-// Insert handles "insert" events.
-func Insert(collection *mongo.Collection, event *model.Event) *model.KafkaResponse {
+// insertArgs are the Insert-specific fields in Event-data,
+// which are not part of Shipment itself.
+type insertArgs struct {
+	// GS1 is the raw GS1 element string, as captured by scanners.
+	GS1 string `json:"gs1,omitempty"`
+	// DigitalLink is the GS1 Digital Link URI, such as from QR codes.
+	DigitalLink string `json:"digitalLink,omitempty"`
+}
+
+// parseInsert creates the Shipment to be inserted from Event-data, filling and
+// validating its fields. Errors for invalid fields are ValidationErrors.
+func parseInsert(data []byte) (*Shipment, error) {
 	ship := &Shipment{}
-	err := json.Unmarshal(event.Data, ship)
+	err := json.Unmarshal(data, ship)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return nil, err
+	}
+
+	if ship.ItemID == (uuuid.UUID{}) {
+		err = errors.New("missing ItemID")
+		return nil, err
+	}
+
+	verrs := ValidationErrors{}
+	if ship.DeletedAt != 0 {
+		verrs = append(verrs, FieldError{"deletedAt", "is only set by delete events"})
+	}
+	if ship.DeletedBy != (uuuid.UUID{}) {
+		verrs = append(verrs, FieldError{"deletedBy", "is only set by delete events"})
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+
+	args := &insertArgs{}
+	err = json.Unmarshal(data, args)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Insert-args")
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data fields")
+		return nil, err
+	}
+	verrs = checkFields(fields, insertFields...)
+	if verrs != nil {
+		return nil, verrs
+	}
+	for _, field := range derivedFields {
+		if _, exists := fields[field]; exists {
+			verrs = append(verrs, FieldError{field, "is derived from other fields"})
+		}
+	}
+	// Inserted shipments are not held or recalled, so the service-actions
+	// cannot be skipped
+	for _, field := range sortedKeys(fields) {
+		if fieldMutability[field] == serviceActionOnly {
+			verrs = append(verrs, FieldError{field, serviceActionMsg})
+		}
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+
+	if args.GS1 != "" {
+		gs1, err := barcode.ParseGS1(args.GS1)
+		if err != nil {
+			verrs = append(verrs, FieldError{"gs1", err.Error()})
+		} else {
+			verrs = append(verrs, ship.applyGS1(gs1, "gs1")...)
+		}
+		if len(verrs) > 0 {
+			return nil, verrs
+		}
+	}
+	if args.DigitalLink != "" {
+		gs1, err := barcode.ParseDigitalLink(args.DigitalLink)
+		if err != nil {
+			verrs = append(verrs, FieldError{"digitalLink", err.Error()})
+		} else {
+			verrs = append(verrs, ship.applyGS1(gs1, "digitalLink")...)
+		}
+		if len(verrs) > 0 {
+			return nil, verrs
+		}
+	}
+
+	verrs = ship.validate()
+	if verrs != nil {
+		return nil, verrs
+	}
+	return ship, nil
+}
+
+// Insert handles "insert" events. Event-data containing an array of shipments,
+// or a "shipments" array, is inserted as a batch.
+func Insert(collection Collection, event *model.Event) *model.KafkaResponse {
+	if isBatchInsert(event.Data) {
+		return InsertBatch(collection, event)
+	}
+
+	ship, err := parseInsert(event.Data)
 	if err != nil {
-		err = errors.Wrap(err, "Insert: Error while unmarshalling Event-data")
+		err = errors.Wrap(err, "Insert")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
 			CorrelationID: event.CorrelationID,
 			Error:         err.Error(),
-			ErrorCode:     InternalError,
+			ErrorCode:     validationCode(err, InternalError),
 			UUID:          event.TimeUUID,
 		}
 	}
 
-	if ship.ItemID == (uuuid.UUID{}) {
-		err = errors.New("missing ItemID")
+	// Duplicate keys would fail the transaction the Event is handled in, so
+	// stored ItemIDs are rejected before inserting
+	stored, err := storedItemIDs(collection, []*Shipment{ship})
+	if err != nil {
+		err = errors.Wrap(err, "Insert: Error finding stored ItemIDs")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if stored[ship.ItemID.String()] {
+		err = ValidationErrors{FieldError{"itemID", "already exists"}}
 		err = errors.Wrap(err, "Insert")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
 			CorrelationID: event.CorrelationID,
 			Error:         err.Error(),
-			ErrorCode:     InternalError,
+			ErrorCode:     ValidationError,
 			UUID:          event.TimeUUID,
 		}
 	}
@@ -67,6 +182,13 @@ func Insert(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 	}
 
 	ship.ID = insertedID
+	// The command succeeded, so errors in publishing are only logged
+	err = publishCreated(event, []*Shipment{ship})
+	if err != nil {
+		err = errors.Wrap(err, "Insert")
+		log.Println(err)
+	}
+
 	result, err := json.Marshal(ship)
 	if err != nil {
 		err = errors.Wrap(err, "Insert: Error marshalling Shipment Insert-result")
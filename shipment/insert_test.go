@@ -0,0 +1,44 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Insert", func() {
+	It("should not insert shipments with stored itemIDs", func() {
+		storedID, err := uuuid.FromString("d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e")
+		Expect(err).ToNot(HaveOccurred())
+		inserted := false
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{&Shipment{ItemID: storedID}}, nil
+			},
+			insertOne: func(data interface{}) (*mgo.InsertOneResult, error) {
+				inserted = true
+				return &mgo.InsertOneResult{}, nil
+			},
+		}
+
+		kr := Insert(collection, newMockEvent("insert", []byte(`{"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e"}`)))
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.Error).To(ContainSubstring("already exists"))
+		Expect(inserted).To(BeFalse())
+	})
+
+	It("should reject service-action fields", func() {
+		_, err := parseInsert([]byte(`{
+			"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e",
+			"status": "recalled",
+			"recallRef": "R-1",
+			"holdBy": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1f"
+		}`))
+		Expect(err).To(Equal(ValidationErrors{
+			FieldError{"holdBy", serviceActionMsg},
+			FieldError{"recallRef", serviceActionMsg},
+			FieldError{"status", serviceActionMsg},
+		}))
+	})
+})
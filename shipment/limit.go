@@ -0,0 +1,166 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/pkg/errors"
+)
+
+// MaxAffected is the maximum number of shipments a single update or delete
+// command can affect, unless the command sets OverrideLimit.
+// A value of 0 disables the limit.
+var MaxAffected = 100
+
+// commandFlags are the flags and options for commands affecting multiple shipments.
+type commandFlags struct {
+	// DryRun returns the shipments the command would affect, without
+	// modifying them.
+	DryRun bool `json:"dryRun,omitempty"`
+	// OverrideLimit allows the command to affect more than MaxAffected shipments.
+	OverrideLimit bool `json:"overrideLimit,omitempty"`
+	// Preconditions must be satisfied by every affected shipment, or the command
+	// fails. These use the same fields and operators as filters.
+	Preconditions map[string]interface{} `json:"preconditions,omitempty"`
+}
+
+// flagFields are the keys of commandFlags in Event-data.
+var flagFields = []string{"dryRun", "overrideLimit", "preconditions"}
+
+type dryRunResult struct {
+	MatchedCount int64    `json:"matchedCount"`
+	ItemIDs      []string `json:"itemIDs"`
+	// Truncated is true if more than MaxAffected shipments match, in which
+	// case only the ItemIDs of the first MaxAffected are included.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// findShipments finds the shipments matching the filter.
+func findShipments(
+	collection Collection, filter map[string]interface{}, opts ...findopt.Find,
+) ([]*Shipment, error) {
+	findResults, err := collection.Find(filter, opts...)
+	if err != nil {
+		err = errors.Wrap(err, "Error in Find")
+		return nil, err
+	}
+
+	ships := make([]*Shipment, 0, len(findResults))
+	for _, r := range findResults {
+		ship, assertOK := r.(*Shipment)
+		if !assertOK {
+			err = errors.New("error asserting find-result to Shipment")
+			return nil, err
+		}
+		ships = append(ships, ship)
+	}
+	return ships, nil
+}
+
+// itemIDs returns the ItemIDs of shipments.
+func itemIDs(ships []*Shipment) []string {
+	ids := make([]string, len(ships))
+	for i, ship := range ships {
+		ids[i] = ship.ItemID.String()
+	}
+	return ids
+}
+
+// isLimited checks if the command is limited to MaxAffected shipments.
+func (f *commandFlags) isLimited() bool {
+	return MaxAffected > 0 && !f.OverrideLimit
+}
+
+// findAffected finds the shipments matching the filter, which the command
+// affects. Limited commands find at most MaxAffected+1 shipments, which
+// suffices for checkLimit, so large matches are not loaded.
+func (f *commandFlags) findAffected(
+	collection Collection, filter map[string]interface{},
+) ([]*Shipment, error) {
+	if !f.isLimited() {
+		return findShipments(collection, filter)
+	}
+	return findShipments(collection, filter, findopt.Limit(int64(MaxAffected+1)))
+}
+
+// checkLimit checks if the number of affected shipments is within MaxAffected.
+func (f *commandFlags) checkLimit(affected int) error {
+	if !f.isLimited() || affected <= MaxAffected {
+		return nil
+	}
+	return errors.Errorf(
+		"command affects more than %d shipments; set overrideLimit to proceed",
+		MaxAffected,
+	)
+}
+
+// limitFilter restricts the filter to the specified shipments, so that
+// shipments matching the filter after these were found are not affected.
+func limitFilter(
+	filter map[string]interface{}, ships []*Shipment,
+) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"itemID": map[string]interface{}{
+					"$in": itemIDs(ships),
+				},
+			},
+		},
+	}
+}
+
+// dryRunResponse creates the KafkaResponse for a dry-run command, containing
+// the shipments it would affect, as found by findAffected. Shipments beyond
+// MaxAffected are only counted, since findAffected does not load them.
+func (f *commandFlags) dryRunResponse(
+	source string,
+	event *model.Event,
+	collection Collection,
+	filter map[string]interface{},
+	ships []*Shipment,
+) *model.KafkaResponse {
+	result := &dryRunResult{
+		MatchedCount: int64(len(ships)),
+	}
+	if f.checkLimit(len(ships)) != nil {
+		count, err := collection.CountDocuments(filter)
+		if err != nil {
+			err = errors.Wrap(err, source+": Error counting affected shipments")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		result.MatchedCount = count
+		result.Truncated = true
+		ships = ships[:MaxAffected]
+	}
+	result.ItemIDs = itemIDs(ships)
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, source+": Error marshalling DryRun-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
@@ -0,0 +1,89 @@
+package shipment
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("blast radius", func() {
+	It("should not treat command-flags as delete filter", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"dryRun":true,"overrideLimit":true}`))
+		kr := Delete(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("blank filter provided"))
+	})
+
+	It("should reject commands affecting more than MaxAffected shipments", func() {
+		flags := &commandFlags{}
+		Expect(flags.checkLimit(MaxAffected)).To(Succeed())
+		err := flags.checkLimit(MaxAffected + 1)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("set overrideLimit to proceed"))
+	})
+
+	It("should allow commands over the limit with overrideLimit", func() {
+		flags := &commandFlags{OverrideLimit: true}
+		Expect(flags.checkLimit(MaxAffected + 1)).To(Succeed())
+	})
+
+	It("should list only MaxAffected shipments but count all matches in dry-runs over the limit", func() {
+		defer func(maxAffected int) {
+			MaxAffected = maxAffected
+		}(MaxAffected)
+		MaxAffected = 1
+		timeUUID, err := uuuid.NewV1()
+		Expect(err).ToNot(HaveOccurred())
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		ships := []*Shipment{&Shipment{ItemID: itemID}, &Shipment{ItemID: otherID}}
+
+		filter := map[string]interface{}{"lot": "A1"}
+		collection := &fakeCollection{
+			countDocuments: func(countFilter interface{}) (int64, error) {
+				Expect(countFilter).To(Equal(filter))
+				return 5, nil
+			},
+		}
+
+		flags := &commandFlags{DryRun: true}
+		kr := flags.dryRunResponse(
+			"Update", &model.Event{TimeUUID: timeUUID}, collection, filter, ships,
+		)
+		Expect(kr.Error).To(BeEmpty())
+		result := &dryRunResult{}
+		err = json.Unmarshal(kr.Result, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(&dryRunResult{
+			MatchedCount: 5,
+			ItemIDs:      []string{itemID.String()},
+			Truncated:    true,
+		}))
+	})
+
+	It("should restrict filter to the found shipments", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		filter := map[string]interface{}{"lot": "A1"}
+		limited := limitFilter(filter, []*Shipment{&Shipment{ItemID: itemID}})
+		Expect(limited).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				filter,
+				map[string]interface{}{
+					"itemID": map[string]interface{}{
+						"$in": []string{itemID.String()},
+					},
+				},
+			},
+		}))
+	})
+})
@@ -2,9 +2,11 @@ package shipment
 
 import (
 	"encoding/json"
+	"reflect"
+	"time"
 
-	util "github.com/TerrexTech/go-commonutils/commonutil"
-
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
 	"github.com/TerrexTech/uuuid"
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
@@ -13,121 +15,100 @@ import (
 
 const AggregateID int8 = 6
 
+const (
+	// StatusAvailable is the Status of shipments which can be sold or donated.
+	// Shipments without a Status are also considered available.
+	StatusAvailable = "available"
+	// StatusHeld is the Status of shipments placed on hold, such as by QA.
+	StatusHeld = "held"
+	// StatusRecalled is the Status of shipments affected by a recall.
+	StatusRecalled = "recalled"
+)
+
 // Shipment defines the Shipment Aggregate.
+// Fields tagged `update:"immutable"` cannot be changed after the shipment is
+// inserted, and fields tagged `update:"setOnce"` cannot be changed once set.
+// Fields tagged `update:"serviceAction"` are only changed by the hold, release
+// and recall service-actions, which check the shipment's Status first.
+// The "codec" tag sets how fields are converted when stored and rendered,
+// for fields where this does not follow from their type.
 type Shipment struct {
-	ID           objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	ItemID       uuuid.UUID        `bson:"itemID,omitempty" json:"itemID,omitempty"`
-	Barcode      string            `bson:"barcode,omitempty" json:"barcode,omitempty"`
-	DateArrived  int64             `bson:"dateArrived,omitempty" json:"dateArrived,omitempty"`
-	DateSold     int64             `bson:"dateSold,omitempty" json:"dateSold,omitempty"`
-	DeviceID     uuuid.UUID        `bson:"deviceID,omitempty" json:"deviceID,omitempty"`
-	DonateWeight float64           `bson:"donateWeight,omitempty" json:"donateWeight,omitempty"`
-	ExpiryDate   int64             `bson:"expiryDate,omitempty" json:"expiryDate,omitempty"`
-	Lot          string            `bson:"lot,omitempty" json:"lot,omitempty"`
-	Name         string            `bson:"name,omitempty" json:"name,omitempty"`
-	Origin       string            `bson:"origin,omitempty" json:"origin,omitempty"`
-	Price        float64           `bson:"price,omitempty" json:"price,omitempty"`
-	Quantity     int64             `bson:"quantity,omitempty" json:"quantity,omitempty"`
-	RSCustomerID uuuid.UUID        `bson:"rsCustomerID,omitempty" json:"rsCustomerID,omitempty"`
-	SalePrice    float64           `bson:"salePrice,omitempty" json:"salePrice,omitempty"`
-	SKU          string            `bson:"sku,omitempty" json:"sku,omitempty"`
-	SoldWeight   float64           `bson:"soldWeight,omitempty" json:"soldWeight,omitempty"`
-	Timestamp    int64             `bson:"timestamp,omitempty" json:"timestamp,omitempty"`
-	TotalWeight  float64           `bson:"totalWeight,omitempty" json:"totalWeight,omitempty"`
-	UPC          int64             `bson:"upc,omitempty" json:"upc,omitempty"`
-	WasteWeight  float64           `bson:"wasteWeight,omitempty" json:"wasteWeight,omitempty"`
-}
-
-// marshalShipment is simplified version of Shipment, for convenience
-// in Marshalling and Unmarshalling operations.
-type marshalShipment struct {
-	ID           objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	ItemID       string            `bson:"itemID,omitempty" json:"itemID,omitempty"`
-	Barcode      string            `bson:"barcode,omitempty" json:"barcode,omitempty"`
-	DateArrived  int64             `bson:"dateArrived,omitempty" json:"dateArrived,omitempty"`
-	DateSold     int64             `bson:"dateSold,omitempty" json:"dateSold,omitempty"`
-	DeviceID     string            `bson:"deviceID,omitempty" json:"deviceID,omitempty"`
-	DonateWeight float64           `bson:"donateWeight,omitempty" json:"donateWeight,omitempty"`
-	ExpiryDate   int64             `bson:"expiryDate,omitempty" json:"expiryDate,omitempty"`
-	Lot          string            `bson:"lot,omitempty" json:"lot,omitempty"`
-	Name         string            `bson:"name,omitempty" json:"name,omitempty"`
-	Origin       string            `bson:"origin,omitempty" json:"origin,omitempty"`
-	Price        float64           `bson:"price,omitempty" json:"price,omitempty"`
-	Quantity     int64             `bson:"quantity,omitempty" json:"quantity,omitempty"`
-	RSCustomerID string            `bson:"rsCustomerID,omitempty" json:"rsCustomerID,omitempty"`
-	SalePrice    float64           `bson:"salePrice,omitempty" json:"salePrice,omitempty"`
-	SKU          string            `bson:"sku,omitempty" json:"sku,omitempty"`
-	SoldWeight   float64           `bson:"soldWeight,omitempty" json:"soldWeight,omitempty"`
-	Timestamp    int64             `bson:"timestamp,omitempty" json:"timestamp,omitempty"`
-	TotalWeight  float64           `bson:"totalWeight,omitempty" json:"totalWeight,omitempty"`
-	UPC          int64             `bson:"upc,omitempty" json:"upc,omitempty"`
-	WasteWeight  float64           `bson:"wasteWeight,omitempty" json:"wasteWeight,omitempty"`
+	ID           objectid.ObjectID      `bson:"_id,omitempty" json:"_id,omitempty"`
+	ItemID       uuuid.UUID             `bson:"itemID,omitempty" json:"itemID,omitempty" update:"immutable"`
+	Attributes   map[string]interface{} `bson:"attributes,omitempty" json:"attributes,omitempty" codec:"attributes"`
+	Barcode      string                 `bson:"barcode,omitempty" json:"barcode,omitempty"`
+	DateArrived  int64                  `bson:"dateArrived,omitempty" json:"dateArrived,omitempty" update:"immutable" codec:"timestamp"`
+	DateHeld     int64                  `bson:"dateHeld,omitempty" json:"dateHeld,omitempty" update:"serviceAction" codec:"timestamp"`
+	DateRecalled int64                  `bson:"dateRecalled,omitempty" json:"dateRecalled,omitempty" update:"serviceAction" codec:"timestamp"`
+	DateSold     int64                  `bson:"dateSold,omitempty" json:"dateSold,omitempty" codec:"timestamp"`
+	DeletedAt    int64                  `bson:"deletedAt,omitempty" json:"deletedAt,omitempty" codec:"timestamp"`
+	DeletedBy    uuuid.UUID             `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	DeviceID     uuuid.UUID             `bson:"deviceID,omitempty" json:"deviceID,omitempty" update:"immutable"`
+	DonateWeight float64                `bson:"donateWeight,omitempty" json:"donateWeight,omitempty" codec:"weight"`
+	ExpiryDate   int64                  `bson:"expiryDate,omitempty" json:"expiryDate,omitempty" codec:"date"`
+	HoldBy       uuuid.UUID             `bson:"holdBy,omitempty" json:"holdBy,omitempty" update:"serviceAction"`
+	HoldReason   string                 `bson:"holdReason,omitempty" json:"holdReason,omitempty" update:"serviceAction"`
+	Lot          string                 `bson:"lot,omitempty" json:"lot,omitempty"`
+	Name         string                 `bson:"name,omitempty" json:"name,omitempty"`
+	Origin       string                 `bson:"origin,omitempty" json:"origin,omitempty"`
+	Price        money.Money            `bson:"price,omitempty" json:"price,omitempty"`
+	Quantity     int64                  `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	RecallRef    string                 `bson:"recallRef,omitempty" json:"recallRef,omitempty" update:"serviceAction"`
+	RSCustomerID uuuid.UUID             `bson:"rsCustomerID,omitempty" json:"rsCustomerID,omitempty" update:"setOnce"`
+	SalePrice    money.Money            `bson:"salePrice,omitempty" json:"salePrice,omitempty"`
+	SKU          string                 `bson:"sku,omitempty" json:"sku,omitempty"`
+	SoldWeight   float64                `bson:"soldWeight,omitempty" json:"soldWeight,omitempty" codec:"weight"`
+	Status       string                 `bson:"status,omitempty" json:"status,omitempty" update:"serviceAction"`
+	Timestamp    int64                  `bson:"timestamp,omitempty" json:"timestamp,omitempty" codec:"timestamp"`
+	TotalWeight  float64                `bson:"totalWeight,omitempty" json:"totalWeight,omitempty" codec:"weight"`
+	UPC          int64                  `bson:"upc,omitempty" json:"upc,omitempty"`
+	WasteWeight  float64                `bson:"wasteWeight,omitempty" json:"wasteWeight,omitempty" codec:"weight"`
+	WeightUnit   string                 `bson:"weightUnit,omitempty" json:"weightUnit,omitempty" codec:"weightUnit"`
 }
 
 // MarshalBSON returns bytes of BSON-type.
 func (i Shipment) MarshalBSON() ([]byte, error) {
-	in := &marshalShipment{
-		ID:           i.ID,
-		ItemID:       i.ItemID.String(),
-		Barcode:      i.Barcode,
-		DateArrived:  i.DateArrived,
-		DateSold:     i.DateSold,
-		DeviceID:     i.DeviceID.String(),
-		DonateWeight: i.DonateWeight,
-		ExpiryDate:   i.ExpiryDate,
-		Lot:          i.Lot,
-		Name:         i.Name,
-		Origin:       i.Origin,
-		Price:        i.Price,
-		Quantity:     i.Quantity,
-		RSCustomerID: i.RSCustomerID.String(),
-		SalePrice:    i.SalePrice,
-		SKU:          i.SKU,
-		SoldWeight:   i.SoldWeight,
-		Timestamp:    i.Timestamp,
-		TotalWeight:  i.TotalWeight,
-		UPC:          i.UPC,
-		WasteWeight:  i.WasteWeight,
-	}
-
-	return bson.Marshal(in)
+	return bson.Marshal(i.storedDoc())
 }
 
 // MarshalJSON returns bytes of JSON-type.
+// Weights are rendered in the shipment's WeightUnit.
 func (i *Shipment) MarshalJSON() ([]byte, error) {
-	in := map[string]interface{}{
-		"itemID":       i.ItemID.String(),
-		"barcode":      i.Barcode,
-		"dateArrived":  i.DateArrived,
-		"dateSold":     i.DateSold,
-		"deviceID":     i.DeviceID.String(),
-		"donateWeight": i.DonateWeight,
-		"expiryDate":   i.ExpiryDate,
-		"lot":          i.Lot,
-		"name":         i.Name,
-		"origin":       i.Origin,
-		"price":        i.Price,
-		"quantity":     i.Quantity,
-		"rsCustomerID": i.RSCustomerID.String(),
-		"salePrice":    i.SalePrice,
-		"sku":          i.SKU,
-		"soldWeight":   i.SoldWeight,
-		"timestamp":    i.Timestamp,
-		"totalWeight":  i.TotalWeight,
-		"upc":          i.UPC,
-		"wasteWeight":  i.WasteWeight,
+	unit := i.weightUnit()
+	now := time.Now()
+	in := make(map[string]interface{}, len(shipmentCodecs)+1)
+	for _, codec := range shipmentCodecs {
+		value := codec.value(i, now)
+		if codec.Kind == idField && value == objectid.NilObjectID {
+			continue
+		}
+		in[codec.Name] = codec.rendered(value, unit)
 	}
-
-	if i.ID != objectid.NilObjectID {
-		in["_id"] = i.ID.Hex()
+	if i.Barcode != "" {
+		digitalLink, err := i.DigitalLink()
+		if err == nil {
+			in["digitalLink"] = digitalLink
+		}
 	}
 	return json.Marshal(in)
 }
 
 // UnmarshalBSON returns BSON-type from bytes.
+// Shipments are decoded into the storedType and converted using the codecs.
+// Documents which cannot be decoded this way are decoded into a map instead,
+// which reports every invalid field.
 func (i *Shipment) UnmarshalBSON(in []byte) error {
+	doc := reflect.New(storedType)
+	err := bson.Unmarshalv2(in, doc.Interface())
+	if err == nil {
+		err = i.unmarshalStored(doc.Elem())
+		if err == nil {
+			return nil
+		}
+	}
+
 	m := make(map[string]interface{})
-	err := bson.Unmarshal(in, m)
+	err = bson.Unmarshal(in, m)
 	if err != nil {
 		err = errors.Wrap(err, "Unmarshal Error")
 		return err
@@ -138,6 +119,7 @@ func (i *Shipment) UnmarshalBSON(in []byte) error {
 }
 
 // UnmarshalJSON returns JSON-type from bytes.
+// Weights are read in the specified WeightUnit, and converted to canonical unit.
 func (i *Shipment) UnmarshalJSON(in []byte) error {
 	m := make(map[string]interface{})
 	err := json.Unmarshal(in, &m)
@@ -147,164 +129,33 @@ func (i *Shipment) UnmarshalJSON(in []byte) error {
 	}
 
 	err = i.unmarshalFromMap(m)
-	return err
+	if err != nil {
+		return err
+	}
+	i.convertWeights(i.weightUnit(), weight.Canonical)
+	return nil
 }
 
-// unmarshalFromMap unmarshals Map into Shipment.
+// unmarshalFromMap unmarshals Map into Shipment. All fields are checked, and
+// the returned ValidationErrors describe every invalid field.
 func (i *Shipment) unmarshalFromMap(m map[string]interface{}) error {
-	var err error
-	var assertOK bool
-
-	// Hoping to discover a better way to do this someday
-	if m["_id"] != nil {
-		i.ID, assertOK = m["_id"].(objectid.ObjectID)
-		if !assertOK {
-			i.ID, err = objectid.FromHex(m["_id"].(string))
-			if err != nil {
-				err = errors.Wrap(err, "Error while asserting ObjectID")
-				return err
-			}
-		}
-	}
+	d := &mapDecoder{m: m}
 
-	if m["itemID"] != nil {
-		i.ItemID, err = uuuid.FromString(m["itemID"].(string))
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting ItemID")
-			return err
-		}
+	// Prices are stored without currency, so currency is read first
+	currency := d.string("currency")
+	if currency == "" {
+		currency = DefaultCurrency
 	}
 
-	if m["deviceID"] != nil {
-		i.DeviceID, err = uuuid.FromString(m["deviceID"].(string))
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting DeviceID")
-			return err
+	fields := reflect.ValueOf(i).Elem()
+	for _, codec := range shipmentCodecs {
+		if codec.Index < 0 {
+			continue
 		}
+		field := fields.Field(codec.Index)
+		value := codec.decode(d, currency, field.Type())
+		field.Set(reflect.ValueOf(value))
 	}
 
-	if m["rsCustomerID"] != nil {
-		i.RSCustomerID, err = uuuid.FromString(m["rsCustomerID"].(string))
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting RSCustomerID")
-			return err
-		}
-	}
-
-	if m["barcode"] != nil {
-		i.Barcode, assertOK = m["barcode"].(string)
-		if !assertOK {
-			return errors.New("Error while asserting Barcode")
-		}
-	}
-	if m["dateArrived"] != nil {
-		i.DateArrived, err = util.AssertInt64(m["dateArrived"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting DateArrived")
-			return err
-		}
-	}
-	if m["dateSold"] != nil {
-		i.DateSold, err = util.AssertInt64(m["dateSold"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting DateSold")
-			return err
-		}
-	}
-	if m["donateWeight"] != nil {
-		i.DonateWeight, err = util.AssertFloat64(m["donateWeight"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting DonateWeight")
-			return err
-		}
-	}
-	if m["expiryDate"] != nil {
-		i.ExpiryDate, err = util.AssertInt64(m["expiryDate"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting ExpiryDate")
-			return err
-		}
-	}
-	if m["lot"] != nil {
-		i.Lot, assertOK = m["lot"].(string)
-		if !assertOK {
-			return errors.New("Error while asserting Lot")
-		}
-	}
-	if m["name"] != nil {
-		i.Name, assertOK = m["name"].(string)
-		if !assertOK {
-			return errors.New("Error while asserting Name")
-		}
-	}
-	if m["origin"] != nil {
-		i.Origin, assertOK = m["origin"].(string)
-		if !assertOK {
-			return errors.New("Error while asserting Origin")
-		}
-	}
-	if m["price"] != nil {
-		i.Price, err = util.AssertFloat64(m["price"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting Price")
-			return err
-		}
-	}
-	if m["quantity"] != nil {
-		i.Quantity, err = util.AssertInt64(m["quantity"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting Quantity")
-			return err
-		}
-	}
-	if m["salePrice"] != nil {
-		i.SalePrice, err = util.AssertFloat64(m["salePrice"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting SalePrice")
-			return err
-		}
-	}
-	if m["sku"] != nil {
-		i.SKU, assertOK = m["sku"].(string)
-		if !assertOK {
-			return errors.New("Error while asserting Sku")
-		}
-	}
-	if m["soldWeight"] != nil {
-		i.SoldWeight, err = util.AssertFloat64(m["soldWeight"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting SoldWeight")
-			return err
-		}
-	}
-	if m["timestamp"] != nil {
-		i.Timestamp, err = util.AssertInt64(m["timestamp"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting Timestamp")
-			return err
-		}
-	}
-	if m["totalWeight"] != nil {
-		i.TotalWeight, err = util.AssertFloat64(m["totalWeight"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting TotalWeight")
-			return err
-		}
-	}
-	if m["upc"] != nil {
-		i.UPC, err = util.AssertInt64(m["upc"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting UPC")
-			return err
-		}
-	}
-	if m["wasteWeight"] != nil {
-		i.WasteWeight, err = util.AssertFloat64(m["wasteWeight"])
-		if err != nil {
-			err = errors.Wrap(err, "Error while asserting WasteWeight")
-			return err
-		}
-	}
-
-	return nil
+	return d.err()
 }
@@ -0,0 +1,50 @@
+package shipment
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MarshalJSON", func() {
+	It("should include digitalLink", func() {
+		ship := &Shipment{
+			Barcode:    "09506000134352",
+			Lot:        "ABC123",
+			ExpiryDate: time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC).Unix(),
+		}
+		marshalShip, err := json.Marshal(ship)
+		Expect(err).ToNot(HaveOccurred())
+
+		m := map[string]interface{}{}
+		err = json.Unmarshal(marshalShip, &m)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m["digitalLink"]).To(Equal(
+			"https://id.example/01/09506000134352/10/ABC123?17=201225",
+		))
+	})
+})
+
+var _ = Describe("unmarshalling", func() {
+	It("should return all invalid fields without panicking", func() {
+		insertArgs := map[string]interface{}{
+			"_id":      true,
+			"itemID":   123,
+			"quantity": "x",
+			"lot":      []string{"a"},
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("_id: expected ObjectID, got boolean"))
+		Expect(kr.Error).To(ContainSubstring("itemID: expected UUID string, got number"))
+		Expect(kr.Error).To(ContainSubstring("quantity: expected integer, got string"))
+		Expect(kr.Error).To(ContainSubstring("lot: expected string, got array"))
+	})
+})
@@ -0,0 +1,238 @@
+package shipment
+
+import (
+	"fmt"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/pkg/errors"
+)
+
+// DefaultCurrency is the currency used for prices specified without a currency,
+// such as legacy float prices.
+var DefaultCurrency = "USD"
+
+// currency returns the currency of shipment's prices.
+func (i *Shipment) currency() string {
+	if i.Price.Currency != "" {
+		return i.Price.Currency
+	}
+	return i.SalePrice.Currency
+}
+
+// assertMoney converts the value to Money. Integers are read as minor units,
+// as stored in Mongo; while floats, such as legacy prices, and decimal strings
+// are read as major units.
+func assertMoney(value interface{}, currency string) (money.Money, error) {
+	switch v := value.(type) {
+	case int:
+		return money.FromMinor(int64(v), currency), nil
+	case int32:
+		return money.FromMinor(int64(v), currency), nil
+	case int64:
+		return money.FromMinor(v, currency), nil
+	case float64:
+		return money.FromFloat(v, currency)
+	case string:
+		return money.Parse(v, currency)
+	}
+	return money.Money{}, errors.Errorf("expected number or decimal string, got %T", value)
+}
+
+// validateMoney checks if the prices have a valid, and the same, currency.
+func (i *Shipment) validateMoney() ValidationErrors {
+	verrs := ValidationErrors{}
+	if i.Price.Currency != "" {
+		err := money.ValidateCurrency(i.Price.Currency)
+		if err != nil {
+			verrs = append(verrs, FieldError{"price", err.Error()})
+		}
+	}
+	if i.SalePrice.Currency != "" {
+		err := money.ValidateCurrency(i.SalePrice.Currency)
+		if err != nil {
+			verrs = append(verrs, FieldError{"salePrice", err.Error()})
+		}
+	}
+	if i.Price.Currency != "" && i.SalePrice.Currency != "" &&
+		i.Price.Currency != i.SalePrice.Currency {
+		verrs = append(verrs, FieldError{
+			"salePrice",
+			"currency must match price currency: " + i.Price.Currency,
+		})
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// priceFields are the Shipment fields stored in minor units of its currency.
+var priceFields = []string{"price", "salePrice"}
+
+// shipmentPrice is a price in an update without currency, which is read in
+// the stored currency of each updated shipment, as done by storedUpdate.
+type shipmentPrice struct {
+	value interface{}
+}
+
+// storedCurrency returns the currency of the shipment's prices, which is the
+// DefaultCurrency for shipments stored without currency.
+func (i *Shipment) storedCurrency() string {
+	if currency := i.currency(); currency != "" {
+		return currency
+	}
+	return DefaultCurrency
+}
+
+// validateUpdateMoney converts the prices in update to minor units, as stored in
+// Mongo. Since the minor units of currencies differ, prices updated without
+// currency are read in the currency of each updated shipment instead.
+func validateUpdateMoney(update map[string]interface{}) ValidationErrors {
+	currency := ""
+	if update["currency"] != nil {
+		c, assertOK := update["currency"].(string)
+		if !assertOK {
+			return ValidationErrors{
+				FieldError{"currency", "must be a string"},
+			}
+		}
+		err := money.ValidateCurrency(c)
+		if err != nil {
+			return ValidationErrors{
+				FieldError{"currency", err.Error()},
+			}
+		}
+		currency = c
+	}
+
+	verrs := ValidationErrors{}
+	for _, field := range priceFields {
+		if update[field] == nil {
+			continue
+		}
+		if currency == "" {
+			update[field] = shipmentPrice{update[field]}
+			continue
+		}
+		m, err := assertMoney(update[field], currency)
+		if err != nil {
+			verrs = append(verrs, FieldError{field, err.Error()})
+			continue
+		}
+		update[field] = m.Amount
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// unchangedPrices returns the price fields which are not in update.
+func unchangedPrices(update map[string]interface{}) []string {
+	unchanged := []string{}
+	for _, field := range priceFields {
+		if _, isUpdated := update[field]; !isUpdated {
+			unchanged = append(unchanged, field)
+		}
+	}
+	return unchanged
+}
+
+// checkCurrencyUpdate checks if the currency in update changes the currency of
+// shipments having prices which are not also updated, since these would be
+// read in the changed currency.
+func checkCurrencyUpdate(update map[string]interface{}, ships []*Shipment) ValidationErrors {
+	currency, isUpdated := update["currency"].(string)
+	if !isUpdated {
+		return nil
+	}
+	unchanged := unchangedPrices(update)
+	if len(unchanged) == 0 {
+		return nil
+	}
+
+	changed := []string{}
+	for _, ship := range ships {
+		if ship.currency() == currency {
+			continue
+		}
+		stored := ship.storedFields(unchanged)
+		for _, field := range unchanged {
+			if !isZero(stored[field]) {
+				changed = append(changed, ship.ItemID.String())
+				break
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return ValidationErrors{FieldError{
+		"currency",
+		fmt.Sprintf(
+			"cannot change while prices are set, unless price and salePrice "+
+				"are updated along with it, for shipments: %v",
+			changed,
+		),
+	}}
+}
+
+// currencyFilter restricts the filter to shipments which have the currency
+// in update, or do not have the prices which are not updated, so the prices of
+// shipments modified after checkCurrencyUpdate are not relabelled.
+func currencyFilter(
+	filter map[string]interface{}, update map[string]interface{},
+) map[string]interface{} {
+	currency, isUpdated := update["currency"].(string)
+	if !isUpdated {
+		return filter
+	}
+	unchanged := unchangedPrices(update)
+	if len(unchanged) == 0 {
+		return filter
+	}
+
+	// Shipments stored without currency have the DefaultCurrency
+	sameCurrency := map[string]interface{}{
+		"currency": currency,
+	}
+	if currency == DefaultCurrency {
+		sameCurrency["currency"] = map[string]interface{}{
+			"$in": []interface{}{nil, "", currency},
+		}
+	}
+	noPrices := map[string]interface{}{}
+	for _, field := range unchanged {
+		noPrices[field] = zeroMatch(int64(0))
+	}
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"$or": []interface{}{sameCurrency, noPrices},
+			},
+		},
+	}
+}
+
+// readPrices reads the prices provided in fields in the currency, such as
+// the currency of an existing shipment when fields do not specify one.
+func (i *Shipment) readPrices(fields map[string]interface{}, currency string) error {
+	if fields["price"] != nil {
+		m, err := assertMoney(fields["price"], currency)
+		if err != nil {
+			return ValidationErrors{FieldError{"price", err.Error()}}
+		}
+		i.Price = m
+	}
+	if fields["salePrice"] != nil {
+		m, err := assertMoney(fields["salePrice"], currency)
+		if err != nil {
+			return ValidationErrors{FieldError{"salePrice", err.Error()}}
+		}
+		i.SalePrice = m
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package shipment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/TerrexTech/agg-shipment-cmd/money"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("money", func() {
+	It("should return error if price currencies differ", func() {
+		ship := &Shipment{
+			Price:     money.FromMinor(1340, "USD"),
+			SalePrice: money.FromMinor(1223, "CAD"),
+		}
+		verrs := ship.validate()
+		Expect(verrs).To(HaveLen(1))
+		Expect(verrs[0].Field).To(Equal("salePrice"))
+	})
+
+	It("should read legacy float prices", func() {
+		ship := &Shipment{}
+		err := json.Unmarshal([]byte(`{"price": 13.4, "currency": "CAD"}`), ship)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ship.Price).To(Equal(money.FromMinor(1340, "CAD")))
+	})
+
+	It("should return error on update if price has extra decimal places", func() {
+		updateArgs := map[string]interface{}{
+			"filter": map[string]interface{}{
+				"lot": "A1",
+			},
+			"update": map[string]interface{}{
+				"currency": "USD",
+				"price":    "13.405",
+			},
+		}
+		mockEvent := newMockEvent("update", updateArgs)
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+	It("should read prices in the updated currency", func() {
+		update := map[string]interface{}{
+			"currency": "JPY",
+			"price":    "1340",
+		}
+		Expect(validateUpdateMoney(update)).To(BeNil())
+		Expect(update["price"]).To(Equal(int64(1340)))
+	})
+
+	It("should read prices updated without currency in each shipment's currency", func() {
+		update := map[string]interface{}{
+			"price": "13.40",
+		}
+		Expect(validateUpdateMoney(update)).To(BeNil())
+
+		cadShip := &Shipment{Price: money.FromMinor(1000, "CAD")}
+		stored, err := cadShip.storedUpdate(update)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored["price"]).To(Equal(int64(1340)))
+		// Shipments stored without currency have the DefaultCurrency
+		legacyShip := &Shipment{}
+		stored, err = legacyShip.storedUpdate(update)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored["price"]).To(Equal(int64(1340)))
+
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		jpyShip := &Shipment{ItemID: itemID, Price: money.FromMinor(1000, "JPY")}
+		_, err = jpyShip.storedUpdate(update)
+		Expect(err).To(HaveOccurred())
+		verrs := checkStoredUpdate(update, []*Shipment{cadShip, jpyShip})
+		Expect(verrs).To(HaveLen(1))
+		Expect(verrs[0].Field).To(Equal("price"))
+		Expect(verrs[0].Message).To(HaveSuffix(
+			fmt.Sprintf("for shipments: [%s]", itemID),
+		))
+	})
+
+	It("should write prices updated without currency in the shipment's currency", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		stored := &Shipment{
+			ID:     objectid.New(),
+			ItemID: itemID,
+			Lot:    "A1",
+			Price:  money.FromMinor(1000, "CAD"),
+		}
+		updates := []interface{}{}
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{stored}, nil
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				updates = append(updates, update)
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		}
+
+		kr := Update(collection, newMockEvent("update", []byte(`{"filter": {"lot": "A1"}, "update": {"price": 13.4}}`)))
+		Expect(kr.Error).To(BeEmpty())
+		Expect(updates).To(HaveLen(1))
+		Expect(updates[0]).To(HaveKeyWithValue("price", int64(1340)))
+	})
+
+	It("should reject currency changes of shipments with unchanged prices", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		ships := []*Shipment{
+			&Shipment{ItemID: itemID, Price: money.FromMinor(1340, "USD")},
+			&Shipment{ItemID: otherID, Price: money.FromMinor(1340, "CAD")},
+		}
+
+		update := map[string]interface{}{
+			"currency": "CAD",
+		}
+		Expect(checkCurrencyUpdate(update, ships)).To(Equal(ValidationErrors{
+			FieldError{"currency", fmt.Sprintf(
+				"cannot change while prices are set, unless price and salePrice "+
+					"are updated along with it, for shipments: [%s]",
+				itemID,
+			)},
+		}))
+		Expect(currencyFilter(map[string]interface{}{}, update)).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{
+					"$or": []interface{}{
+						map[string]interface{}{"currency": "CAD"},
+						map[string]interface{}{
+							"price":     map[string]interface{}{"$in": []interface{}{nil, int64(0)}},
+							"salePrice": map[string]interface{}{"$in": []interface{}{nil, int64(0)}},
+						},
+					},
+				},
+			},
+		}))
+
+		update["price"] = int64(1500)
+		Expect(checkCurrencyUpdate(update, ships)).To(BeNil())
+	})
+})
@@ -0,0 +1,142 @@
+package shipment
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	util "github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/TerrexTech/uuuid"
+)
+
+const (
+	// immutable fields cannot be changed after the shipment is inserted.
+	immutable = "immutable"
+	// setOnce fields can be set if blank, but cannot be changed afterwards.
+	setOnce = "setOnce"
+	// serviceActionOnly fields are only changed by the hold, release and recall
+	// service-actions.
+	serviceActionOnly = "serviceAction"
+)
+
+// fieldMutability is the mutability of Shipment fields, as declared by the
+// "update" tag of Shipment fields. Fields without the tag can be updated freely.
+var fieldMutability = mutabilityTags(reflect.TypeOf(Shipment{}))
+
+// mutabilityTags returns the "update" tags of struct-type t, keyed by the
+// JSON field-names.
+func mutabilityTags(t reflect.Type) map[string]string {
+	tags := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("update")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		tags[name] = tag
+	}
+	return tags
+}
+
+// isBlank checks if the stored value is blank. Blank UUIDs are stored as
+// the nil UUID, rather than being omitted.
+func isBlank(value interface{}) bool {
+	return isZero(value) || value == (uuuid.UUID{}).String()
+}
+
+// sameValue checks if the values are equal, comparing numbers by value
+// regardless of their types.
+func sameValue(a interface{}, b interface{}) bool {
+	aNum, aErr := util.AssertFloat64(a)
+	bNum, bErr := util.AssertFloat64(b)
+	if aErr == nil && bErr == nil {
+		return aNum == bNum
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// checkMutability checks if the update changes immutable fields, or set-once
+// fields which are already set, of any of the shipments. Setting a field to
+// its current value is allowed.
+func checkMutability(update map[string]interface{}, ships []*Shipment) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, field := range sortedKeys(update) {
+		mutability := fieldMutability[field]
+		if mutability == "" {
+			continue
+		}
+
+		changed := []string{}
+		for _, ship := range ships {
+			stored := ship.storedFields([]string{field})[field]
+			if mutability == setOnce && isBlank(stored) {
+				continue
+			}
+			if !sameValue(stored, update[field]) {
+				changed = append(changed, ship.ItemID.String())
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		msg := "field is immutable"
+		switch mutability {
+		case setOnce:
+			msg = "field can only be set once, and is already set"
+		case serviceActionOnly:
+			msg = serviceActionMsg
+		}
+		if len(ships) > 1 {
+			msg = fmt.Sprintf("%s for shipments: %v", msg, changed)
+		}
+		verrs = append(verrs, FieldError{field, msg})
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// mutabilityFilter restricts the filter to shipments whose immutable,
+// set-once and service-action fields in update are blank or have the updated
+// values, so these are not changed by shipments modified after checkMutability,
+// such as by a concurrent hold.
+func mutabilityFilter(
+	filter map[string]interface{}, update map[string]interface{},
+) map[string]interface{} {
+	guards := []interface{}{filter}
+	for _, field := range sortedKeys(update) {
+		mutability := fieldMutability[field]
+		if mutability == "" {
+			continue
+		}
+		if mutability == serviceActionOnly {
+			guards = append(guards, map[string]interface{}{
+				field: zeroMatch(update[field]),
+			})
+			continue
+		}
+		allowed := []interface{}{update[field]}
+		if mutability == setOnce {
+			allowed = append(allowed, nil, (uuuid.UUID{}).String())
+			if update[field] != nil {
+				allowed = append(
+					allowed, reflect.Zero(reflect.TypeOf(update[field])).Interface(),
+				)
+			}
+		}
+		guards = append(guards, map[string]interface{}{
+			field: map[string]interface{}{
+				"$in": allowed,
+			},
+		})
+	}
+	if len(guards) == 1 {
+		return filter
+	}
+	return map[string]interface{}{
+		"$and": guards,
+	}
+}
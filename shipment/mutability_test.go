@@ -0,0 +1,156 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("mutability", func() {
+	It("should declare the immutable, set-once and service-action fields", func() {
+		Expect(fieldMutability).To(Equal(map[string]string{
+			"dateArrived":  immutable,
+			"dateHeld":     serviceActionOnly,
+			"dateRecalled": serviceActionOnly,
+			"deviceID":     immutable,
+			"holdBy":       serviceActionOnly,
+			"holdReason":   serviceActionOnly,
+			"itemID":       immutable,
+			"recallRef":    serviceActionOnly,
+			"rsCustomerID": setOnce,
+			"status":       serviceActionOnly,
+		}))
+	})
+
+	It("should reject changes to immutable fields", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{ItemID: itemID, DateArrived: 1540000000}
+
+		verrs := checkMutability(map[string]interface{}{
+			"dateArrived": float64(1540000001),
+			"itemID":      otherID.String(),
+			"lot":         "A1",
+		}, []*Shipment{current})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"dateArrived", "field is immutable"},
+			FieldError{"itemID", "field is immutable"},
+		}))
+	})
+
+	It("should allow setting immutable fields to their current values", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{ItemID: itemID, DateArrived: 1540000000}
+
+		verrs := checkMutability(map[string]interface{}{
+			"dateArrived": float64(1540000000),
+			"itemID":      itemID.String(),
+		}, []*Shipment{current})
+		Expect(verrs).To(BeNil())
+	})
+
+	It("should allow setting set-once fields only if blank", func() {
+		customerID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		blank := &Shipment{ItemID: otherID}
+		set := &Shipment{ItemID: customerID, RSCustomerID: customerID}
+
+		update := map[string]interface{}{
+			"rsCustomerID": otherID.String(),
+		}
+		Expect(checkMutability(update, []*Shipment{blank})).To(BeNil())
+		verrs := checkMutability(update, []*Shipment{blank, set})
+		Expect(verrs).To(HaveLen(1))
+		Expect(verrs[0].Field).To(Equal("rsCustomerID"))
+		Expect(verrs[0].Message).To(ContainSubstring(
+			"field can only be set once, and is already set for shipments",
+		))
+		Expect(verrs[0].Message).To(ContainSubstring(customerID.String()))
+	})
+
+	It("should reject updates and patches of service-action fields", func() {
+		verrs := checkUpdatePolicy(map[string]interface{}{
+			"holdReason": "",
+			"lot":        "A1",
+			"status":     StatusAvailable,
+		})
+		Expect(verrs).To(Equal(ValidationErrors{
+			FieldError{"update.holdReason", serviceActionMsg},
+			FieldError{"update.status", serviceActionMsg},
+		}))
+
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{ItemID: itemID, Status: StatusHeld, HoldReason: "QA"}
+		unpatched, patched, tested, err := patchShipment(current, &patchUpdate{
+			MergePatch: map[string]interface{}{
+				"holdReason": nil,
+				"status":     StatusAvailable,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		update, _ := patchDiff(current, unpatched, patched, tested)
+		verrs = checkUpdatePolicy(update)
+		Expect(verrs).To(ConsistOf(
+			FieldError{"update.holdReason", serviceActionMsg},
+			FieldError{"update.status", serviceActionMsg},
+		))
+	})
+
+	It("should only merge service-action fields with their current values", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{ItemID: itemID, Status: StatusHeld}
+		ship := &Shipment{ItemID: itemID, Status: StatusAvailable}
+
+		update := mergeUpdate(ship, map[string]interface{}{
+			"status": StatusAvailable,
+		})
+		Expect(checkMutability(update, []*Shipment{current})).To(Equal(ValidationErrors{
+			FieldError{"status", serviceActionMsg},
+		}))
+
+		ship.Status = StatusHeld
+		update = mergeUpdate(ship, map[string]interface{}{
+			"status": StatusHeld,
+		})
+		Expect(checkMutability(update, []*Shipment{current})).To(BeNil())
+		Expect(mutabilityFilter(map[string]interface{}{}, update)).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{"status": StatusHeld},
+			},
+		}))
+	})
+
+	It("should restrict the filter to shipments with blank set-once fields", func() {
+		filter := mutabilityFilter(map[string]interface{}{
+			"lot": "A1",
+		}, map[string]interface{}{
+			"origin":       "farm",
+			"rsCustomerID": "c",
+		})
+		Expect(filter).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				map[string]interface{}{"lot": "A1"},
+				map[string]interface{}{
+					"rsCustomerID": map[string]interface{}{
+						"$in": []interface{}{"c", nil, (uuuid.UUID{}).String(), ""},
+					},
+				},
+			},
+		}))
+
+		filter = mutabilityFilter(map[string]interface{}{
+			"lot": "A1",
+		}, map[string]interface{}{
+			"origin": "farm",
+		})
+		Expect(filter).To(Equal(map[string]interface{}{"lot": "A1"}))
+	})
+})
@@ -0,0 +1,391 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/core/command"
+	"github.com/mongodb/mongo-go-driver/core/readconcern"
+	"github.com/mongodb/mongo-go-driver/core/writeconcern"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/transactionopt"
+	"github.com/pkg/errors"
+)
+
+// States of OutboxEntries.
+const (
+	// outboxReady entries are to be relayed.
+	outboxReady = "ready"
+	// outboxSent entries were relayed, and are retained until these expire,
+	// so redelivered Events are not handled again.
+	outboxSent = "sent"
+)
+
+// duplicateKeyCode is the Mongo error-code for duplicate keys.
+const duplicateKeyCode = 11000
+
+// defaultOutboxBatchSize is the maximum number of entries relayed at once, if
+// the Outbox has no BatchSize.
+const defaultOutboxBatchSize = 100
+
+// transactionAttempts is the number of transactions in which an Event is
+// handled, if the earlier transactions fail, such as due to write-conflicts
+// with concurrent Events.
+const transactionAttempts = 3
+
+// OutboxEntry stores the KafkaResponse and DomainEvents of a handled Event,
+// until these are relayed to Kafka.
+type OutboxEntry struct {
+	// ID is the TimeUUID of the handled Event.
+	ID            string `bson:"_id" json:"_id"`
+	CorrelationID string `bson:"correlationID,omitempty" json:"correlationID,omitempty"`
+	State         string `bson:"state,omitempty" json:"state,omitempty"`
+	// Response is the JSON of the KafkaResponse.
+	Response string `bson:"response,omitempty" json:"response,omitempty"`
+	// Events is the JSON array of DomainEvents.
+	Events    string `bson:"events,omitempty" json:"events,omitempty"`
+	CreatedAt int64  `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	// Seq orders the entries by their creation. ObjectIDs increase
+	// monotonically within a process, and by the second across processes.
+	Seq objectid.ObjectID `bson:"seq" json:"seq"`
+	// The sentAt Date, from which sent entries expire, is not decoded, since
+	// the Mongo driver in use cannot decode Dates into structs.
+}
+
+// sentUpdate marks an entry as sent. It is a struct, since the Mongo driver
+// in use only encodes time.Time as a Date in structs.
+type sentUpdate struct {
+	State  string    `bson:"state"`
+	SentAt time.Time `bson:"sentAt"`
+}
+
+// Outbox stores the KafkaResponses and DomainEvents of handled Events in
+// Mongo, from where these are relayed to Kafka.
+//
+// Each Event is handled in a Mongo transaction, which also inserts the entry
+// of the Event, so the entry is stored if and only if the changes to the
+// shipments are. Transactions require Mongo to run as a replica-set.
+type Outbox struct {
+	// Collection must use the same Client as the collection of shipments.
+	Collection *mongo.Collection
+	// BatchSize is the maximum number of entries relayed at once.
+	// defaultOutboxBatchSize is used if it is zero.
+	BatchSize int64
+
+	eventsLock sync.Mutex
+	// events are the DomainEvents of the Events being handled, by their TimeUUID.
+	events map[string][]*DomainEvent
+}
+
+// Handle handles the Event using Handle in a Mongo transaction, and stores
+// its KafkaResponse and DomainEvents in the Outbox in the same transaction.
+// Events which already have an entry, such as when redelivered, are not
+// handled again. Failed transactions are retried, except when the Handler's
+// command failed and the error is not transient, in which case the Handler's
+// KafkaResponse is returned. A KafkaResponse is returned only if no
+// transaction could be committed, and must then be produced directly.
+func (o *Outbox) Handle(
+	collection *mongo.Collection, event *model.Event,
+) *model.KafkaResponse {
+	var (
+		kafkaResp *model.KafkaResponse
+		err       error
+	)
+	for attempt := 1; attempt <= transactionAttempts; attempt++ {
+		var isNew bool
+		kafkaResp, isNew, err = o.handleInTransaction(collection, event)
+		if err == nil {
+			if !isNew {
+				err = errors.Errorf("Event %s was already handled", event.TimeUUID)
+				err = errors.Wrap(err, "Outbox")
+				log.Println(err)
+			}
+			return nil
+		}
+		err = errors.Wrapf(
+			err, "Outbox: Error in transaction %d of %d", attempt, transactionAttempts,
+		)
+		log.Println(err)
+		// Writes of the Handler failing with other errors would fail again,
+		// so only transient errors are retried
+		if kafkaResp != nil && kafkaResp.Error != "" && !isTransient(err) {
+			return kafkaResp
+		}
+	}
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Error:         err.Error(),
+		ErrorCode:     DatabaseError,
+		UUID:          event.TimeUUID,
+	}
+}
+
+// handleInTransaction handles the Event and inserts its entry in a single
+// transaction, and returns the KafkaResponse of the Handler. False is
+// returned if the Event already has an entry, in which case the transaction
+// is aborted. If an operation of the Handler fails, its error is returned,
+// since Mongo aborts transactions on failed operations.
+func (o *Outbox) handleInTransaction(
+	collection *mongo.Collection, event *model.Event,
+) (*model.KafkaResponse, bool, error) {
+	session, err := collection.Connection.Client.DriverClient().StartSession()
+	if err != nil {
+		err = errors.Wrap(err, "Error starting session")
+		return nil, false, err
+	}
+	// Ending the session aborts the transaction, unless it was committed
+	defer func() {
+		ctx, cancel := o.collection().timeoutContext()
+		defer cancel()
+		session.EndSession(ctx)
+	}()
+
+	err = session.StartTransaction(
+		transactionopt.ReadConcern(readconcern.Snapshot()),
+		transactionopt.WriteConcern(writeconcern.New(writeconcern.WMajority())),
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error starting transaction")
+		return nil, false, err
+	}
+
+	id := event.TimeUUID.String()
+	o.startEvents(id)
+	shipments := &sessionCollection{collection: collection, session: session}
+	kafkaResp := Handle(shipments, event)
+	events := o.takeEvents(id)
+	if shipments.err != nil {
+		err = errors.Wrap(shipments.err, "Error in operation of Handler")
+		return kafkaResp, false, err
+	}
+
+	entry, err := newOutboxEntry(event, kafkaResp, events)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating outbox entry")
+		return kafkaResp, false, err
+	}
+	_, err = (&sessionCollection{collection: o.Collection, session: session}).InsertOne(entry)
+	if isDuplicateKey(err) {
+		return kafkaResp, false, nil
+	}
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting outbox entry")
+		return kafkaResp, false, err
+	}
+
+	ctx, cancel := o.collection().timeoutContext()
+	defer cancel()
+	err = session.CommitTransaction(ctx)
+	if err != nil {
+		err = errors.Wrap(err, "Error committing transaction")
+		return kafkaResp, false, err
+	}
+	return kafkaResp, true, nil
+}
+
+// newOutboxEntry creates the entry, ready to be relayed, for the Event.
+func newOutboxEntry(
+	event *model.Event, kafkaResp *model.KafkaResponse, events []*DomainEvent,
+) (*OutboxEntry, error) {
+	response, err := json.Marshal(kafkaResp)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling KafkaResponse")
+		return nil, err
+	}
+	entry := &OutboxEntry{
+		ID:            event.TimeUUID.String(),
+		CorrelationID: event.CorrelationID.String(),
+		State:         outboxReady,
+		Response:      string(response),
+		CreatedAt:     time.Now().Unix(),
+		Seq:           objectid.New(),
+	}
+
+	if len(events) > 0 {
+		marshalEvents, err := json.Marshal(events)
+		if err != nil {
+			err = errors.Wrap(err, "Error marshalling DomainEvents")
+			return nil, err
+		}
+		entry.Events = string(marshalEvents)
+	}
+	return entry, nil
+}
+
+// startEvents starts collecting the DomainEvents of the Event with the
+// TimeUUID. DomainEvents collected in earlier transactions are discarded.
+func (o *Outbox) startEvents(id string) {
+	o.eventsLock.Lock()
+	defer o.eventsLock.Unlock()
+
+	if o.events == nil {
+		o.events = map[string][]*DomainEvent{}
+	}
+	o.events[id] = []*DomainEvent{}
+}
+
+// takeEvents returns the collected DomainEvents of the Event with the
+// TimeUUID, and stops collecting these.
+func (o *Outbox) takeEvents(id string) []*DomainEvent {
+	o.eventsLock.Lock()
+	defer o.eventsLock.Unlock()
+
+	events := o.events[id]
+	delete(o.events, id)
+	return events
+}
+
+// AddEvents collects the DomainEvents for the entries of the Events which
+// caused these, which must be being handled by the Outbox. It is used as
+// PublishEvents, which Handlers call once with all DomainEvents of a command.
+func (o *Outbox) AddEvents(events []*DomainEvent) error {
+	o.eventsLock.Lock()
+	defer o.eventsLock.Unlock()
+
+	for _, event := range events {
+		id := event.CausationID.String()
+		causedEvents, isHandled := o.events[id]
+		if !isHandled {
+			err := errors.Errorf("Event %s is not being handled by the Outbox", id)
+			return err
+		}
+		o.events[id] = append(causedEvents, event)
+	}
+	return nil
+}
+
+// Ready returns the next batch of entries ready to be relayed, in the order
+// these were created.
+func (o *Outbox) Ready() ([]*OutboxEntry, error) {
+	batchSize := o.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultOutboxBatchSize
+	}
+	entries, err := o.find(
+		map[string]interface{}{
+			"state": outboxReady,
+		},
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("seq", 1))),
+		findopt.Limit(batchSize),
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding ready entries")
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MarkSent marks the entry as relayed. Entries are relayed again if these
+// are not marked, so delivery is at-least-once. Sent entries expire once the
+// TTL-index on sentAt is exceeded.
+func (o *Outbox) MarkSent(entry *OutboxEntry) error {
+	_, err := o.collection().UpdateMany(
+		map[string]interface{}{
+			"_id":   entry.ID,
+			"state": outboxReady,
+		},
+		&sentUpdate{
+			State:  outboxSent,
+			SentAt: time.Now(),
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error in UpdateMany")
+		return err
+	}
+	return nil
+}
+
+// collection returns the Collection of entries. mongo.Collection is not used
+// directly, since it can only convert ObjectIDs as "_id".
+func (o *Outbox) collection() *sessionCollection {
+	return &sessionCollection{collection: o.Collection}
+}
+
+// find finds the entries matching the filter.
+func (o *Outbox) find(
+	filter map[string]interface{}, opts ...findopt.Find,
+) ([]*OutboxEntry, error) {
+	findResults, err := o.collection().Find(filter, opts...)
+	if err != nil {
+		err = errors.Wrap(err, "Error in Find")
+		return nil, err
+	}
+
+	entries := make([]*OutboxEntry, 0, len(findResults))
+	for _, r := range findResults {
+		entry, assertOK := r.(*OutboxEntry)
+		if !assertOK {
+			err = errors.New("error asserting find-result to OutboxEntry")
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Messages returns the KafkaResponse and the JSON of each DomainEvent
+// of the entry.
+func (e *OutboxEntry) Messages() (*model.KafkaResponse, []json.RawMessage, error) {
+	kafkaResp := &model.KafkaResponse{}
+	err := json.Unmarshal([]byte(e.Response), kafkaResp)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling KafkaResponse")
+		return nil, nil, err
+	}
+
+	events := []json.RawMessage{}
+	if e.Events != "" {
+		err = json.Unmarshal([]byte(e.Events), &events)
+		if err != nil {
+			err = errors.Wrap(err, "Error unmarshalling DomainEvents")
+			return nil, nil, err
+		}
+	}
+	return kafkaResp, events, nil
+}
+
+// isTransient checks if the error is labelled by Mongo as transient, so the
+// transaction can be retried. Commits with unknown results are also retried,
+// since Events which were committed already have an entry. Duplicate keys are
+// transient too, since Handlers look up stored ItemIDs before inserting, so
+// these only occur if a concurrent Event inserted the ItemID after the
+// transaction started.
+func isTransient(err error) bool {
+	if isDuplicateKey(err) {
+		return true
+	}
+	cmdErr, isCmdErr := errors.Cause(err).(command.Error)
+	if !isCmdErr {
+		return false
+	}
+	return cmdErr.HasErrorLabel(command.TransientTransactionError) ||
+		cmdErr.HasErrorLabel(command.UnknownTransactionCommitResult)
+}
+
+// isDuplicateKey checks if the error is a duplicate-key error from Mongo.
+func isDuplicateKey(err error) bool {
+	var writeErrs mgo.WriteErrors
+	switch e := errors.Cause(err).(type) {
+	case mgo.WriteErrors:
+		writeErrs = e
+	case mgo.BulkWriteError:
+		writeErrs = e.WriteErrors
+	default:
+		return false
+	}
+	for _, writeErr := range writeErrs {
+		if writeErr.Code == duplicateKeyCode {
+			return true
+		}
+	}
+	return false
+}
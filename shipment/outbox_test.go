@@ -0,0 +1,160 @@
+package shipment
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/core/command"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Outbox", func() {
+	var mockEvent *model.Event
+
+	BeforeEach(func() {
+		mockEvent = newMockEvent("update", []byte{})
+	})
+
+	It("should create ready entries with the response and events", func() {
+		kafkaResp := &model.KafkaResponse{
+			AggregateID:   6,
+			CorrelationID: mockEvent.CorrelationID,
+			Result:        []byte(`{"matchedCount":1}`),
+			UUID:          mockEvent.TimeUUID,
+		}
+		events := []*DomainEvent{
+			&DomainEvent{Type: ShipmentSold},
+			&DomainEvent{Type: ShipmentWasted},
+		}
+
+		entry, err := newOutboxEntry(mockEvent, kafkaResp, events)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.ID).To(Equal(mockEvent.TimeUUID.String()))
+		Expect(entry.CorrelationID).To(Equal(mockEvent.CorrelationID.String()))
+		Expect(entry.State).To(Equal(outboxReady))
+		Expect(entry.CreatedAt).ToNot(BeZero())
+
+		resp, messages, err := entry.Messages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp).To(Equal(kafkaResp))
+		Expect(messages).To(HaveLen(2))
+		event := &DomainEvent{}
+		err = json.Unmarshal(messages[1], event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.Type).To(Equal(ShipmentWasted))
+
+		entry, err = newOutboxEntry(mockEvent, kafkaResp, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Events).To(BeEmpty())
+		_, messages, err = entry.Messages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(messages).To(BeEmpty())
+
+		entry.Response = ""
+		_, _, err = entry.Messages()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should collect events only for the Events being handled", func() {
+		outbox := &Outbox{}
+		id := mockEvent.TimeUUID.String()
+		event := &DomainEvent{
+			Type:        ShipmentSold,
+			CausationID: mockEvent.TimeUUID,
+		}
+
+		err := outbox.AddEvents([]*DomainEvent{event})
+		Expect(err).To(HaveOccurred())
+
+		outbox.startEvents(id)
+		err = outbox.AddEvents([]*DomainEvent{event})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(outbox.takeEvents(id)).To(Equal([]*DomainEvent{event}))
+
+		// Events of earlier transactions are discarded
+		outbox.startEvents(id)
+		err = outbox.AddEvents([]*DomainEvent{event})
+		Expect(err).ToNot(HaveOccurred())
+		outbox.startEvents(id)
+		Expect(outbox.takeEvents(id)).To(BeEmpty())
+
+		err = outbox.AddEvents([]*DomainEvent{event})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should sequence entries in the order these are created", func() {
+		timeUUID, err := uuuid.NewV1()
+		Expect(err).ToNot(HaveOccurred())
+		event := &model.Event{TimeUUID: timeUUID}
+		first, err := newOutboxEntry(event, &model.KafkaResponse{}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		second, err := newOutboxEntry(event, &model.KafkaResponse{}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first.Seq.Hex() < second.Seq.Hex()).To(BeTrue())
+	})
+
+	It("should convert entries and sent-updates to documents", func() {
+		seq := objectid.New()
+		doc, err := toDocument(&OutboxEntry{ID: "a", State: outboxReady, Seq: seq})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc.Lookup("_id").StringValue()).To(Equal("a"))
+		Expect(doc.Lookup("seq").ObjectID()).To(Equal(seq))
+		Expect(doc.Lookup("sentAt")).To(BeNil())
+
+		doc, err = toDocument(&sentUpdate{State: outboxSent, SentAt: time.Now()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc.Lookup("sentAt").Type()).To(Equal(bson.TypeDateTime))
+
+		// Zero ObjectIDs are generated by Mongo instead
+		doc, err = toDocument(&Shipment{Lot: "A1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc.Lookup("_id")).To(BeNil())
+		id := objectid.New()
+		doc, err = toDocument(&Shipment{ID: id})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc.Lookup("_id").ObjectID()).To(Equal(id))
+	})
+
+	It("should detect duplicate-key errors", func() {
+		err := errors.Wrap(mgo.WriteErrors{
+			mgo.WriteError{Code: duplicateKeyCode},
+		}, "Error in InsertOne")
+		Expect(isDuplicateKey(err)).To(BeTrue())
+		err = mgo.BulkWriteError{
+			WriteErrors: mgo.WriteErrors{mgo.WriteError{Index: 2, Code: duplicateKeyCode}},
+		}
+		Expect(isDuplicateKey(err)).To(BeTrue())
+
+		err = mgo.WriteErrors{mgo.WriteError{Code: 121}}
+		Expect(isDuplicateKey(err)).To(BeFalse())
+		Expect(isDuplicateKey(errors.New("connection refused"))).To(BeFalse())
+		Expect(isDuplicateKey(nil)).To(BeFalse())
+	})
+	It("should detect transient errors", func() {
+		err := errors.Wrap(command.Error{
+			Code:   112,
+			Labels: []string{command.TransientTransactionError},
+		}, "Error in UpdateMany")
+		Expect(isTransient(err)).To(BeTrue())
+
+		err = command.Error{Labels: []string{command.UnknownTransactionCommitResult}}
+		Expect(isTransient(err)).To(BeTrue())
+
+		err = errors.Wrap(mgo.WriteErrors{
+			mgo.WriteError{Code: duplicateKeyCode},
+		}, "Error in InsertOne")
+		Expect(isTransient(err)).To(BeTrue())
+
+		err = mgo.WriteErrors{mgo.WriteError{Code: 121}}
+		Expect(isTransient(err)).To(BeFalse())
+		Expect(isTransient(command.Error{Code: 251})).To(BeFalse())
+		Expect(isTransient(nil)).To(BeFalse())
+	})
+})
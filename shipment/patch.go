@@ -0,0 +1,533 @@
+package shipment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+// patchUpdate is the Event-data for patching a single shipment, using either
+// JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7396).
+type patchUpdate struct {
+	ItemID     string                 `json:"itemID"`
+	Patch      []patchOp              `json:"patch,omitempty"`
+	MergePatch map[string]interface{} `json:"mergePatch,omitempty"`
+	// ReturnAfter includes the patched shipment in Update-result.
+	ReturnAfter bool `json:"returnAfter,omitempty"`
+	// ReturnBefore includes the shipment, as before the patch, in Update-result.
+	ReturnBefore bool `json:"returnBefore,omitempty"`
+}
+
+// patchOp is a JSON Patch operation. Only the "add", "replace", "remove" and
+// "test" operations are supported, since Shipment fields are not nested.
+// Paths reference top-level fields, or attributes as "/attributes/<name>".
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchTestError is when a "test" operation of JSON Patch fails.
+type patchTestError struct {
+	Field    string
+	Path     string
+	Expected interface{}
+	Actual   interface{}
+}
+
+func (e *patchTestError) Error() string {
+	return fmt.Sprintf(
+		"test failed for %s: expected %v, got %v", e.Path, e.Expected, e.Actual,
+	)
+}
+
+// isPatchUpdate checks if Event-data contains a JSON Patch or JSON Merge Patch.
+func isPatchUpdate(data []byte) bool {
+	patch := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &patch)
+	if err != nil {
+		return false
+	}
+	_, isPatch := patch["patch"]
+	_, isMergePatch := patch["mergePatch"]
+	return isPatch || isMergePatch
+}
+
+// patchField returns the field referenced by the JSON Pointer path, and the
+// attribute's name if the path references an attribute.
+func patchField(path string) (string, string, error) {
+	tokens := strings.Split(path, "/")
+	isField := len(tokens) == 2 && tokens[0] == ""
+	isAttribute := len(tokens) == 3 && tokens[0] == "" && tokens[1] == "attributes"
+	if !isField && !isAttribute {
+		return "", "", errors.Errorf(
+			"expected path to a top-level field or an attribute, got: %s", path,
+		)
+	}
+	for t, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		tokens[t] = strings.Replace(token, "~0", "~", -1)
+	}
+	if isAttribute {
+		return tokens[1], tokens[2], nil
+	}
+	return tokens[1], "", nil
+}
+
+// applyJSONPatch applies the JSON Patch operations to doc, in order. The tested
+// fields are returned, so these can be checked again when writing the patch.
+// Tested attributes are returned as their paths, such as "attributes.organic".
+func applyJSONPatch(doc map[string]interface{}, ops []patchOp) ([]string, error) {
+	tested := []string{}
+	for i, op := range ops {
+		opField := fmt.Sprintf("patch.%d", i)
+		field, name, err := patchField(op.Path)
+		if err != nil {
+			return nil, ValidationErrors{FieldError{opField, err.Error()}}
+		}
+
+		// Attributes are patched in the attributes object, which is added
+		// if the shipment has no attributes
+		target := doc
+		key := field
+		if name != "" {
+			attributes, isObject := doc[field].(map[string]interface{})
+			if !isObject {
+				if doc[field] != nil {
+					return nil, ValidationErrors{
+						FieldError{opField, "expected attributes to be an object"},
+					}
+				}
+				attributes = map[string]interface{}{}
+				if op.Op == "add" {
+					doc[field] = attributes
+				}
+			}
+			target = attributes
+			key = name
+			field = "attributes." + name
+		}
+
+		switch op.Op {
+		case "add":
+			target[key] = op.Value
+		case "replace", "remove":
+			if _, exists := target[key]; !exists {
+				return nil, ValidationErrors{
+					FieldError{opField, "path not found: " + op.Path},
+				}
+			}
+			if op.Op == "replace" {
+				target[key] = op.Value
+			} else {
+				delete(target, key)
+			}
+		case "test":
+			if !reflect.DeepEqual(target[key], op.Value) {
+				return nil, &patchTestError{field, op.Path, op.Value, target[key]}
+			}
+			tested = append(tested, field)
+		default:
+			return nil, ValidationErrors{
+				FieldError{opField, "unsupported operation: " + op.Op},
+			}
+		}
+	}
+	return tested, nil
+}
+
+// applyMergePatch applies the JSON Merge Patch to doc. Null values remove
+// fields, and objects are merged recursively into the existing objects.
+func applyMergePatch(doc map[string]interface{}, patch map[string]interface{}) {
+	for field, value := range patch {
+		if value == nil {
+			delete(doc, field)
+			continue
+		}
+		valuePatch, isObject := value.(map[string]interface{})
+		if !isObject {
+			doc[field] = value
+			continue
+		}
+		// Objects replace non-object values, after removing their null values
+		target, isTargetObject := doc[field].(map[string]interface{})
+		if !isTargetObject {
+			target = map[string]interface{}{}
+			doc[field] = target
+		}
+		applyMergePatch(target, valuePatch)
+	}
+}
+
+// zeroMatch matches the stored value in filters. Zero values are omitted when
+// shipments are inserted, so these also match missing fields.
+func zeroMatch(value interface{}) interface{} {
+	if isZero(value) {
+		return map[string]interface{}{
+			"$in": []interface{}{nil, value},
+		}
+	}
+	return value
+}
+
+// patchDiff returns the update containing the fields changed by the patch, and
+// the filter matching the current values of changed and tested fields. The patch
+// is hence only written if the shipment was not modified after being read.
+// Changes are found by comparing with the unpatched document, so differences
+// from converting shipment to JSON, such as rounding of weights, are ignored.
+func patchDiff(
+	current *Shipment, unpatched *Shipment, patched *Shipment, tested []string,
+) (map[string]interface{}, map[string]interface{}) {
+	stored := current.storedFields(knownFields)
+	before := unpatched.storedFields(knownFields)
+	after := patched.storedFields(knownFields)
+
+	update := map[string]interface{}{}
+	filter := map[string]interface{}{
+		"itemID": current.ItemID.String(),
+	}
+	for _, field := range knownFields {
+		if field == "_id" || isDerivedField(field) {
+			continue
+		}
+		if field == "attributes" {
+			diffAttributes(
+				current.Attributes, unpatched.Attributes, patched.Attributes, update, filter,
+			)
+			continue
+		}
+		if !reflect.DeepEqual(before[field], after[field]) {
+			update[field] = after[field]
+			filter[field] = zeroMatch(stored[field])
+		}
+	}
+	for _, field := range tested {
+		if isAttributePath(field) {
+			name := strings.TrimPrefix(field, "attributes.")
+			filter[field] = zeroMatch(current.Attributes[name])
+			continue
+		}
+		if field == "attributes" {
+			for name, value := range current.Attributes {
+				filter["attributes."+name] = zeroMatch(value)
+			}
+			continue
+		}
+		if value, isField := stored[field]; isField {
+			filter[field] = zeroMatch(value)
+		}
+	}
+	return update, filter
+}
+
+// diffAttributes adds the attributes changed by the patch to update, and their
+// current values to filter, using the paths of the attributes. Attributes are
+// compared individually, since Mongo matches whole documents only if their
+// keys are in the same order. Removed attributes are set to nil.
+func diffAttributes(
+	current map[string]interface{},
+	before map[string]interface{},
+	after map[string]interface{},
+	update map[string]interface{},
+	filter map[string]interface{},
+) {
+	names := map[string]interface{}{}
+	for name := range before {
+		names[name] = nil
+	}
+	for name := range after {
+		names[name] = nil
+	}
+	for _, name := range sortedKeys(names) {
+		if reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		path := "attributes." + name
+		update[path] = after[name]
+		filter[path] = zeroMatch(current[name])
+	}
+}
+
+// docShipment converts the JSON document to Shipment.
+func docShipment(doc map[string]interface{}) (*Shipment, error) {
+	docJSON, err := json.Marshal(doc)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling shipment document")
+		return nil, err
+	}
+	ship := &Shipment{}
+	err = json.Unmarshal(docJSON, ship)
+	if err != nil {
+		return nil, err
+	}
+	return ship, nil
+}
+
+// patchShipment applies the patch to the shipment's JSON document, and returns
+// the unpatched and the patched Shipments, along with the tested fields.
+// The patched Shipment is validated.
+func patchShipment(
+	current *Shipment, args *patchUpdate,
+) (*Shipment, *Shipment, []string, error) {
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling current shipment")
+		return nil, nil, nil, err
+	}
+	doc := map[string]interface{}{}
+	err = json.Unmarshal(currentJSON, &doc)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling current shipment")
+		return nil, nil, nil, err
+	}
+	// These are not stored as part of shipment, and cannot be patched
+	delete(doc, "_id")
+	delete(doc, "digitalLink")
+
+	unpatched, err := docShipment(doc)
+	if err != nil {
+		err = errors.Wrap(err, "Error converting current shipment")
+		return nil, nil, nil, err
+	}
+
+	// Attributes are normalized like the patched attributes, so only the
+	// patched attributes differ. Errors are reported for the patched shipment.
+	validateAttributes(unpatched.Attributes)
+
+	tested := []string{}
+	if args.Patch != nil {
+		tested, err = applyJSONPatch(doc, args.Patch)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		applyMergePatch(doc, args.MergePatch)
+	}
+
+	verrs := checkFields(doc)
+	if verrs != nil {
+		return nil, nil, nil, verrs
+	}
+	patched, err := docShipment(doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	verrs = patched.validate()
+	if verrs != nil {
+		return nil, nil, nil, verrs
+	}
+
+	patched.ID = current.ID
+	return unpatched, patched, tested, nil
+}
+
+// PatchUpdate handles "update" events containing a JSON Patch or JSON Merge Patch
+// for the shipment with specified ItemID. The patch is applied to the shipment's
+// JSON document, as returned in responses, and validated before being written.
+// JSON Patch "test" operations are preconditions for the patch.
+func PatchUpdate(collection Collection, event *model.Event) *model.KafkaResponse {
+	args := &patchUpdate{}
+	err := json.Unmarshal(event.Data, args)
+	if err != nil {
+		err = errors.Wrap(err, "PatchUpdate: Error while unmarshalling Event-data")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if (args.Patch == nil) == (args.MergePatch == nil) {
+		err = errors.New("exactly one of patch or mergePatch must be provided")
+		err = errors.Wrap(err, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	itemID, err := uuuid.FromString(args.ItemID)
+	if err != nil || itemID == (uuuid.UUID{}) {
+		err = errors.Errorf("invalid ItemID: %s", args.ItemID)
+		err = errors.Wrap(err, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	current, err := findShipment(collection, itemID)
+	if err != nil {
+		err = errors.Wrap(err, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if current == nil {
+		err = errors.Errorf("shipment with ItemID %s not found", itemID)
+		err = errors.Wrap(err, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	unpatched, patched, tested, err := patchShipment(current, args)
+	if testErr, isTestErr := errors.Cause(err).(*patchTestError); isTestErr {
+		log.Println(errors.Wrap(err, "PatchUpdate"))
+		return preconditionResponse("PatchUpdate", event, &preconditionResult{
+			Failed: []preconditionFailure{
+				preconditionFailure{
+					ItemID: itemID.String(),
+					Current: map[string]interface{}{
+						testErr.Field: testErr.Actual,
+					},
+				},
+			},
+		})
+	}
+	if err != nil {
+		err = errors.Wrap(err, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     validationCode(err, InternalError),
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	update, filter := patchDiff(current, unpatched, patched, tested)
+	verrs := checkUpdatePolicy(update)
+	if len(verrs) == 0 {
+		verrs = checkMutability(update, []*Shipment{current})
+		verrs = append(verrs, checkCurrencyUpdate(update, []*Shipment{current})...)
+	}
+	if len(verrs) > 0 {
+		err = errors.Wrap(verrs, "PatchUpdate")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	filter = notDeleted(filter)
+	if isSaleUpdate(update) {
+		if current.Status == StatusHeld || current.Status == StatusRecalled {
+			err = errors.Wrap(blockedError([]*Shipment{current}), "PatchUpdate")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     InvalidStateError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		filter = saleFilter(filter)
+	}
+
+	result := &updateResult{}
+	after := patched
+	if len(update) > 0 {
+		change, isModified, err := writeShipment(collection, filter, nil, current, update)
+		if err != nil {
+			err = errors.Wrap(err, "PatchUpdate")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		// The changed or tested fields were modified after the patch was applied
+		if change == nil {
+			modified, err := findShipment(collection, itemID)
+			if err != nil || modified == nil {
+				err = errors.Errorf(
+					"shipment with ItemID %s was modified during patch", itemID,
+				)
+				err = errors.Wrap(err, "PatchUpdate")
+				log.Println(err)
+				return &model.KafkaResponse{
+					AggregateID:   event.AggregateID,
+					CorrelationID: event.CorrelationID,
+					Error:         err.Error(),
+					ErrorCode:     InvalidStateError,
+					UUID:          event.TimeUUID,
+				}
+			}
+			return preconditionResponse("PatchUpdate", event, &preconditionResult{
+				Failed: preconditionFailures([]*Shipment{modified}, filter),
+			})
+		}
+		result.MatchedCount = 1
+		if isModified {
+			result.ModifiedCount = 1
+		}
+		after = change.After
+
+		// The command succeeded, so errors in publishing are only logged
+		err = publish(event, []shipmentChange{*change})
+		if err != nil {
+			err = errors.Wrap(err, "PatchUpdate")
+			log.Println(err)
+		}
+	}
+	if args.ReturnBefore {
+		result.Before = []*Shipment{current}
+	}
+	if args.ReturnAfter {
+		result.After = []*Shipment{after}
+	}
+
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "PatchUpdate: Error marshalling Shipment Update-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
@@ -0,0 +1,335 @@
+package shipment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("patch attributes", func() {
+	BeforeEach(func() {
+		schema, err := ParseAttributeSchema([]byte(`{
+			"organic": {"type": "boolean"},
+			"poLine": {"type": "integer"}
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		AttributeSchema = schema
+	})
+
+	AfterEach(func() {
+		AttributeSchema = map[string]AttributeDef{}
+	})
+
+	It("should merge objects recursively", func() {
+		doc := map[string]interface{}{
+			"lot": "A1",
+			"attributes": map[string]interface{}{
+				"organic": true,
+				"poLine":  12.0,
+				"supplier": map[string]interface{}{
+					"name": "farm",
+					"code": "F1",
+				},
+			},
+		}
+		applyMergePatch(doc, map[string]interface{}{
+			"origin": "farm",
+			"attributes": map[string]interface{}{
+				"organic": nil,
+				"grade":   "A",
+				"supplier": map[string]interface{}{
+					"code": nil,
+				},
+				"certification": map[string]interface{}{
+					"body":    "USDA",
+					"expired": nil,
+				},
+			},
+		})
+		Expect(doc).To(Equal(map[string]interface{}{
+			"lot":    "A1",
+			"origin": "farm",
+			"attributes": map[string]interface{}{
+				"grade":  "A",
+				"poLine": 12.0,
+				"supplier": map[string]interface{}{
+					"name": "farm",
+				},
+				"certification": map[string]interface{}{
+					"body": "USDA",
+				},
+			},
+		}))
+	})
+
+	It("should replace non-object values with merged objects", func() {
+		doc := map[string]interface{}{
+			"attributes": "organic",
+		}
+		applyMergePatch(doc, map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"organic": true,
+				"grade":   nil,
+			},
+		})
+		Expect(doc).To(Equal(map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"organic": true,
+			},
+		}))
+	})
+
+	It("should accept paths to attributes", func() {
+		field, name, err := patchField("/attributes/organic")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(field).To(Equal("attributes"))
+		Expect(name).To(Equal("organic"))
+
+		field, name, err = patchField("/attributes/a~1b~0c")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(field).To(Equal("attributes"))
+		Expect(name).To(Equal("a/b~c"))
+
+		field, name, err = patchField("/lot")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(field).To(Equal("lot"))
+		Expect(name).To(BeEmpty())
+
+		_, _, err = patchField("/attributes/organic/0")
+		Expect(err).To(HaveOccurred())
+		_, _, err = patchField("/lot/0")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should apply JSON Patch operations to attributes", func() {
+		doc := map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"organic": true,
+				"poLine":  12.0,
+			},
+		}
+		tested, err := applyJSONPatch(doc, []patchOp{
+			patchOp{Op: "test", Path: "/attributes/organic", Value: true},
+			patchOp{Op: "add", Path: "/attributes/grade", Value: "A"},
+			patchOp{Op: "replace", Path: "/attributes/poLine", Value: 14.0},
+			patchOp{Op: "remove", Path: "/attributes/organic"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tested).To(Equal([]string{"attributes.organic"}))
+		Expect(doc).To(Equal(map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"grade":  "A",
+				"poLine": 14.0,
+			},
+		}))
+
+		_, err = applyJSONPatch(doc, []patchOp{
+			patchOp{Op: "remove", Path: "/attributes/organic"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("path not found: /attributes/organic")))
+
+		_, err = applyJSONPatch(doc, []patchOp{
+			patchOp{Op: "test", Path: "/attributes/grade", Value: "B"},
+		})
+		testErr, isTestErr := err.(*patchTestError)
+		Expect(isTestErr).To(BeTrue())
+		Expect(testErr.Field).To(Equal("attributes.grade"))
+		Expect(testErr.Actual).To(Equal("A"))
+	})
+
+	It("should add attributes to shipments without attributes", func() {
+		doc := map[string]interface{}{"lot": "A1"}
+		_, err := applyJSONPatch(doc, []patchOp{
+			patchOp{Op: "replace", Path: "/attributes/organic", Value: true},
+		})
+		Expect(err).To(MatchError(ContainSubstring("path not found")))
+		Expect(doc).ToNot(HaveKey("attributes"))
+
+		_, err = applyJSONPatch(doc, []patchOp{
+			patchOp{Op: "add", Path: "/attributes/organic", Value: true},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc["attributes"]).To(Equal(map[string]interface{}{"organic": true}))
+	})
+
+	It("should only write tested attributes if unchanged", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{
+			ItemID: itemID,
+			Lot:    "A1",
+			Attributes: map[string]interface{}{
+				"organic": true,
+				"poLine":  int64(12),
+			},
+		}
+		unpatched, patched, tested, err := patchShipment(current, &patchUpdate{
+			Patch: []patchOp{
+				patchOp{Op: "test", Path: "/attributes/organic", Value: true},
+				patchOp{Op: "replace", Path: "/lot", Value: "B2"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		update, filter := patchDiff(current, unpatched, patched, tested)
+		Expect(update).To(Equal(map[string]interface{}{"lot": "B2"}))
+		Expect(filter).To(Equal(map[string]interface{}{
+			"itemID":             itemID.String(),
+			"lot":                "A1",
+			"attributes.organic": true,
+		}))
+	})
+})
+
+var _ = Describe("patch update", func() {
+	It("should return error if both patch and mergePatch are provided", func() {
+		mockEvent := newMockEvent("update", []byte(`{"itemID":"d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e","patch":[],"mergePatch":{}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("exactly one of patch or mergePatch"))
+	})
+
+	It("should return error if itemID is invalid", func() {
+		mockEvent := newMockEvent("update", []byte(`{"itemID":"x","mergePatch":{"lot":"A1"}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("invalid ItemID"))
+	})
+
+	It("should write the patched fields along with the derived fields", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+
+		current := &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			TotalWeight: 10,
+		}
+		var written interface{}
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{current}, nil
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				written = update
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		}
+		mockEvent := newMockEvent("update", []byte(fmt.Sprintf(
+			`{"itemID":"%s","mergePatch":{"soldWeight":4}}`, itemID,
+		)))
+		kr := PatchUpdate(collection, mockEvent)
+		Expect(kr.Error).To(BeEmpty())
+		Expect(written).To(Equal(map[string]interface{}{
+			"soldWeight":      4.0,
+			"daysUntilExpiry": int64(0),
+			"margin":          int64(0),
+			"remainingWeight": 6.0,
+			"sellThrough":     40.0,
+		}))
+
+		result := &updateResult{}
+		err = json.Unmarshal(kr.Result, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.MatchedCount).To(Equal(int64(1)))
+		Expect(result.ModifiedCount).To(Equal(int64(1)))
+	})
+
+	It("should apply JSON Patch and return the changed and tested fields", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		current := &Shipment{
+			ItemID:      itemID,
+			Lot:         "A1",
+			Origin:      "farm",
+			SoldWeight:  3.2,
+			TotalWeight: 10,
+		}
+		unpatched, patched, tested, err := patchShipment(current, &patchUpdate{
+			Patch: []patchOp{
+				patchOp{Op: "test", Path: "/soldWeight", Value: 3.2},
+				patchOp{Op: "replace", Path: "/lot", Value: "B2"},
+				patchOp{Op: "remove", Path: "/origin"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patched.Lot).To(Equal("B2"))
+		Expect(patched.Origin).To(BeEmpty())
+		Expect(tested).To(Equal([]string{"soldWeight"}))
+
+		update, filter := patchDiff(current, unpatched, patched, tested)
+		Expect(update).To(Equal(map[string]interface{}{
+			"lot":    "B2",
+			"origin": "",
+		}))
+		Expect(filter).To(Equal(map[string]interface{}{
+			"itemID":     itemID.String(),
+			"lot":        "A1",
+			"origin":     "farm",
+			"soldWeight": 3.2,
+		}))
+	})
+
+	It("should fail JSON Patch if test operation fails", func() {
+		current := &Shipment{SoldWeight: 3.2}
+		_, _, _, err := patchShipment(current, &patchUpdate{
+			Patch: []patchOp{
+				patchOp{Op: "test", Path: "/soldWeight", Value: 3.0},
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		_, isTestErr := err.(*patchTestError)
+		Expect(isTestErr).To(BeTrue())
+	})
+
+	It("should validate the patched shipment", func() {
+		current := &Shipment{Lot: "A1"}
+		_, _, _, err := patchShipment(current, &patchUpdate{
+			MergePatch: map[string]interface{}{
+				"quantity":  "x",
+				"expiryDte": 1540000000,
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(validationCode(err, InternalError)).To(Equal(int16(ValidationError)))
+		Expect(err.Error()).To(ContainSubstring("expiryDte"))
+	})
+
+	It("should apply JSON Merge Patch, removing null fields", func() {
+		current := &Shipment{Lot: "A1", Origin: "farm"}
+		_, patched, _, err := patchShipment(current, &patchUpdate{
+			MergePatch: map[string]interface{}{
+				"lot":    "B2",
+				"origin": nil,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patched.Lot).To(Equal("B2"))
+		Expect(patched.Origin).To(BeEmpty())
+	})
+
+	It("should reject unsupported operations and nested paths", func() {
+		_, err := applyJSONPatch(map[string]interface{}{}, []patchOp{
+			patchOp{Op: "move", Path: "/lot"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("unsupported operation: move")))
+		_, err = applyJSONPatch(map[string]interface{}{}, []patchOp{
+			patchOp{Op: "add", Path: "/lot/0", Value: "A1"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("expected path to a top-level field")))
+	})
+})
@@ -0,0 +1,219 @@
+package shipment
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxFilterDepth is the maximum nesting of logical operators ($and, $or, $nor)
+// allowed in command filters.
+var MaxFilterDepth = 3
+
+// logicalOperators combine the filter-documents in their array-values.
+var logicalOperators = map[string]bool{
+	"$and": true,
+	"$nor": true,
+	"$or":  true,
+}
+
+// fieldOperators compare a field's value. Operators such as $where, $expr,
+// $regex and $exists are not allowed, since they can execute code or
+// match every shipment.
+var fieldOperators = map[string]bool{
+	"$eq":  true,
+	"$gt":  true,
+	"$gte": true,
+	"$in":  true,
+	"$lt":  true,
+	"$lte": true,
+	"$ne":  true,
+	"$nin": true,
+}
+
+// isFilterField checks if shipments can be filtered using the field.
+// Shipments are selected using ItemID, so "_id" is not allowed. Attributes
+// are filtered using their paths, such as "attributes.organic".
+func isFilterField(field string) bool {
+	if isAttributePath(field) {
+		_, isDefined := AttributeSchema[strings.TrimPrefix(field, "attributes.")]
+		return isDefined || !StrictFields
+	}
+	return field != "_id" && field != "attributes" && isKnownField(field)
+}
+
+// checkFilter checks if the filter only uses the allowed fields and operators,
+// with logical operators nested at most MaxFilterDepth levels.
+// Errors are reported for the path of each offending key, such as
+// "filter.$or.0.lot.$regex".
+func checkFilter(filter map[string]interface{}) ValidationErrors {
+	verrs := checkFilterDoc("filter", filter, 0)
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+func checkFilterDoc(path string, doc map[string]interface{}, depth int) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, key := range sortedKeys(doc) {
+		value := doc[key]
+		keyPath := path + "." + key
+
+		if logicalOperators[key] {
+			if depth+1 > MaxFilterDepth {
+				verrs = append(verrs, FieldError{
+					keyPath,
+					fmt.Sprintf("exceeds max filter depth of %d", MaxFilterDepth),
+				})
+				continue
+			}
+			subDocs, assertOK := value.([]interface{})
+			if !assertOK || len(subDocs) == 0 {
+				verrs = append(verrs, FieldError{keyPath, "expected non-empty array of filters"})
+				continue
+			}
+			for i, sd := range subDocs {
+				subDoc, assertOK := sd.(map[string]interface{})
+				if !assertOK {
+					verrs = append(verrs, FieldError{
+						fmt.Sprintf("%s.%d", keyPath, i),
+						"expected object, got " + typeName(sd),
+					})
+					continue
+				}
+				verrs = append(
+					verrs, checkFilterDoc(fmt.Sprintf("%s.%d", keyPath, i), subDoc, depth+1)...,
+				)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, "$") {
+			verrs = append(verrs, FieldError{keyPath, "operator not allowed"})
+			continue
+		}
+		if !isFilterField(key) {
+			verrs = append(verrs, FieldError{keyPath, "field cannot be filtered"})
+			continue
+		}
+		verrs = append(verrs, checkFilterValue(keyPath, value)...)
+	}
+	return verrs
+}
+
+// checkFilterValue checks the value matched for a field, which is either
+// a scalar, or an object of field-operators.
+func checkFilterValue(path string, value interface{}) ValidationErrors {
+	ops, isDoc := value.(map[string]interface{})
+	if !isDoc {
+		if !isScalar(value) {
+			return ValidationErrors{
+				FieldError{path, "expected scalar or operators, got " + typeName(value)},
+			}
+		}
+		return nil
+	}
+	if len(ops) == 0 {
+		return ValidationErrors{FieldError{path, "expected scalar or operators, got empty object"}}
+	}
+
+	verrs := ValidationErrors{}
+	for _, op := range sortedKeys(ops) {
+		opValue := ops[op]
+		opPath := path + "." + op
+		if !fieldOperators[op] {
+			verrs = append(verrs, FieldError{opPath, "operator not allowed"})
+			continue
+		}
+
+		if op == "$in" || op == "$nin" {
+			values, assertOK := opValue.([]interface{})
+			if !assertOK {
+				verrs = append(verrs, FieldError{opPath, "expected array, got " + typeName(opValue)})
+				continue
+			}
+			for i, v := range values {
+				if !isScalar(v) {
+					verrs = append(verrs, FieldError{
+						fmt.Sprintf("%s.%d", opPath, i),
+						"expected scalar, got " + typeName(v),
+					})
+				}
+			}
+			continue
+		}
+		if !isScalar(opValue) {
+			verrs = append(verrs, FieldError{opPath, "expected scalar, got " + typeName(opValue)})
+		}
+	}
+	return verrs
+}
+
+// serviceActionMsg is the error for updates of service-action fields.
+const serviceActionMsg = "field is only changed by hold, release and recall service-actions"
+
+// checkUpdatePolicy checks if the update only sets Shipment fields to scalar
+// values, and attributes to an object or their paths to scalar values.
+// Fields set by delete events and service-actions cannot be updated, so
+// these cannot bypass the checks of their Status.
+// Updates are applied using $set, so update-operators are not allowed.
+func checkUpdatePolicy(update map[string]interface{}) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, key := range sortedKeys(update) {
+		value := update[key]
+		keyPath := "update." + key
+		switch {
+		case strings.HasPrefix(key, "$"):
+			verrs = append(verrs, FieldError{keyPath, "operator not allowed"})
+		case key == "attributes":
+			if _, isDoc := value.(map[string]interface{}); !isDoc && value != nil {
+				verrs = append(verrs, FieldError{keyPath, "expected object, got " + typeName(value)})
+			}
+		case isAttributePath(key):
+			if !isScalar(value) {
+				verrs = append(verrs, FieldError{keyPath, "expected scalar, got " + typeName(value)})
+			}
+		case strings.Contains(key, "."):
+			verrs = append(verrs, FieldError{keyPath, "nested fields cannot be updated"})
+		case key == "_id":
+			verrs = append(verrs, FieldError{keyPath, "field cannot be updated"})
+		case !isKnownField(key):
+			// Checked regardless of StrictFields, so updates cannot write
+			// arbitrary fields
+			verrs = append(verrs, FieldError{keyPath, unknownFieldMsg(key, knownFields)})
+		case key == "deletedAt" || key == "deletedBy":
+			verrs = append(verrs, FieldError{keyPath, "field is only set by delete events"})
+		case fieldMutability[key] == serviceActionOnly:
+			verrs = append(verrs, FieldError{keyPath, serviceActionMsg})
+		case isDerivedField(key):
+			verrs = append(verrs, FieldError{keyPath, "field is derived from other fields"})
+		case !isScalar(value):
+			verrs = append(verrs, FieldError{keyPath, "expected scalar, got " + typeName(value)})
+		}
+	}
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// isScalar checks if value is a JSON scalar, such as a string or number.
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case nil, bool, string, float64, int, int32, int64:
+		return true
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order, so the errors for maps
+// are reported in a stable order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
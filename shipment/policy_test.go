@@ -0,0 +1,77 @@
+package shipment
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("query policy", func() {
+	It("should reject $where in delete filter", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"$where":"sleep(1000)"}`))
+		kr := Delete(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("filter.$where: operator not allowed"))
+	})
+
+	It("should reject $exists on _id in delete filter", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"_id":{"$exists":true}}`))
+		kr := Delete(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("filter._id: field cannot be filtered"))
+	})
+
+	It("should reject disallowed operators nested in logical operators", func() {
+		mockEvent := newMockEvent("update", []byte(`{"filter":{"$or":[{"lot":"A1"},{"sku":{"$regex":".*"}}]},"update":{"origin":"x"}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("filter.$or.1.sku.$regex: operator not allowed"))
+	})
+
+	It("should reject filters nested deeper than MaxFilterDepth", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"$and":[{"$or":[{"$and":[{"$or":[{"lot":"A1"}]}]}]}]}`))
+		kr := Delete(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("exceeds max filter depth"))
+	})
+
+	It("should reject operators and objects in update", func() {
+		mockEvent := newMockEvent("update", []byte(`{"filter":{"lot":"A1"},"update":{"$unset":{"lot":1},"origin":{"$gt":""}}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("update.$unset: operator not allowed"))
+		Expect(kr.Error).To(ContainSubstring("update.origin: expected scalar, got object"))
+	})
+
+	It("should allow comparison operators on Shipment fields", func() {
+		filter := map[string]interface{}{
+			"$or": []interface{}{
+				map[string]interface{}{"lot": "A1"},
+				map[string]interface{}{
+					"expiryDate": map[string]interface{}{"$lt": 1540000000.0},
+					"sku":        map[string]interface{}{"$in": []interface{}{"a", "b"}},
+				},
+			},
+		}
+		Expect(checkFilter(filter)).To(BeNil())
+	})
+})
@@ -0,0 +1,129 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/pkg/errors"
+)
+
+// preconditionFailure describes a shipment which did not satisfy the
+// preconditions, with the current values of the precondition fields.
+type preconditionFailure struct {
+	ItemID  string                 `json:"itemID"`
+	Current map[string]interface{} `json:"current"`
+}
+
+type preconditionResult struct {
+	MatchedCount  int64                 `json:"matchedCount"`
+	ModifiedCount int64                 `json:"modifiedCount"`
+	Failed        []preconditionFailure `json:"failed"`
+}
+
+// checkPreconditions checks if the preconditions use the same fields and
+// operators as allowed in filters.
+func checkPreconditions(preconditions map[string]interface{}) ValidationErrors {
+	verrs := checkFilterDoc("preconditions", preconditions, 0)
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// preconditionFields returns the fields used in preconditions, including the
+// fields nested in logical operators.
+func preconditionFields(preconditions map[string]interface{}) []string {
+	fields := []string{}
+	for _, key := range sortedKeys(preconditions) {
+		if !logicalOperators[key] {
+			fields = append(fields, key)
+			continue
+		}
+		subDocs, _ := preconditions[key].([]interface{})
+		for _, sd := range subDocs {
+			if subDoc, isDoc := sd.(map[string]interface{}); isDoc {
+				fields = append(fields, preconditionFields(subDoc)...)
+			}
+		}
+	}
+	return fields
+}
+
+// withPreconditions restricts the filter to shipments satisfying the
+// preconditions, so these are checked in the same operation as the write.
+func withPreconditions(
+	filter map[string]interface{}, preconditions map[string]interface{},
+) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{filter, preconditions},
+	}
+}
+
+// findFailedPreconditions finds the shipments matching the filter which do not
+// satisfy the preconditions.
+func findFailedPreconditions(
+	collection Collection,
+	filter map[string]interface{},
+	preconditions map[string]interface{},
+) ([]*Shipment, error) {
+	failedFilter := map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"$nor": []interface{}{preconditions},
+			},
+		},
+	}
+	failed, err := findShipments(collection, failedFilter)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding shipments failing preconditions")
+		return nil, err
+	}
+	return failed, nil
+}
+
+// preconditionFailures describes the shipments which failed the preconditions,
+// with their current values as stored in Mongo.
+func preconditionFailures(
+	failed []*Shipment, preconditions map[string]interface{},
+) []preconditionFailure {
+	fields := preconditionFields(preconditions)
+	failures := make([]preconditionFailure, len(failed))
+	for i, ship := range failed {
+		failures[i] = preconditionFailure{
+			ItemID:  ship.ItemID.String(),
+			Current: ship.storedFields(fields),
+		}
+	}
+	return failures
+}
+
+// preconditionResponse creates the PreconditionFailedError KafkaResponse. Its
+// Result describes the failed shipments, and the counts of any shipments
+// which were modified.
+func preconditionResponse(
+	source string, event *model.Event, result *preconditionResult,
+) *model.KafkaResponse {
+	itemIDs := make([]string, len(result.Failed))
+	for i, f := range result.Failed {
+		itemIDs[i] = f.ItemID
+	}
+	err := errors.Errorf("preconditions failed for shipments: %v", itemIDs)
+	err = errors.Wrap(err, source)
+	log.Println(err)
+
+	resultMarshal, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		marshalErr = errors.Wrap(marshalErr, source+": Error marshalling Precondition-result")
+		log.Println(marshalErr)
+	}
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Error:         err.Error(),
+		ErrorCode:     PreconditionFailedError,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
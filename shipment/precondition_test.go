@@ -0,0 +1,72 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("preconditions", func() {
+	It("should return ValidationError on Update with invalid preconditions", func() {
+		mockEvent := newMockEvent("update", []byte(`{"filter":{"lot":"A1"},"update":{"origin":"farm"},"preconditions":{"$where":"x","lott":"A1"}}`))
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("preconditions.$where"))
+		Expect(kr.Error).To(ContainSubstring("preconditions.lott"))
+	})
+
+	It("should return ValidationError on Delete with invalid preconditions", func() {
+		mockEvent := newMockEvent("delete", []byte(`{"lot":"A1","preconditions":{"$where":"x"}}`))
+		kr := Delete(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("preconditions.$where"))
+	})
+
+	It("should accept preconditions using filter operators", func() {
+		verrs := checkPreconditions(map[string]interface{}{
+			"status": "shipped",
+			"$or": []interface{}{
+				map[string]interface{}{"soldWeight": map[string]interface{}{"$lt": 2}},
+				map[string]interface{}{"lot": "A1"},
+			},
+		})
+		Expect(verrs).To(BeNil())
+	})
+
+	It("should return the fields used in preconditions", func() {
+		fields := preconditionFields(map[string]interface{}{
+			"status": "shipped",
+			"$or": []interface{}{
+				map[string]interface{}{"soldWeight": map[string]interface{}{"$lt": 2}},
+				map[string]interface{}{"lot": "A1"},
+			},
+		})
+		Expect(fields).To(ConsistOf("status", "soldWeight", "lot"))
+	})
+
+	It("should describe failed shipments with their current values", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		failures := preconditionFailures(
+			[]*Shipment{&Shipment{ItemID: itemID, Lot: "B2", SoldWeight: 3.2}},
+			map[string]interface{}{
+				"lot":        "A1",
+				"soldWeight": map[string]interface{}{"$lt": 2},
+			},
+		)
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].ItemID).To(Equal(itemID.String()))
+		Expect(failures[0].Current).To(Equal(map[string]interface{}{
+			"lot":        "B2",
+			"soldWeight": 3.2,
+		}))
+	})
+})
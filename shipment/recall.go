@@ -0,0 +1,207 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/pkg/errors"
+)
+
+// recallArgs selects the shipments to be recalled. All specified
+// selectors must match.
+type recallArgs struct {
+	Lot       string `json:"lot,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+	UPC       int64  `json:"upc,omitempty"`
+	RecallRef string `json:"recallRef,omitempty"`
+	// WeightUnit is the unit in which on-hand weights are returned.
+	WeightUnit string `json:"weightUnit,omitempty"`
+}
+
+type recalledItem struct {
+	ItemID       string  `json:"itemID"`
+	OnHandWeight float64 `json:"onHandWeight"`
+}
+
+type recallResult struct {
+	MatchedCount  int64          `json:"matchedCount,omitempty"`
+	ModifiedCount int64          `json:"modifiedCount,omitempty"`
+	Items         []recalledItem `json:"items"`
+	OnHandWeight  float64        `json:"onHandWeight"`
+	WeightUnit    string         `json:"weightUnit"`
+}
+
+// filter creates the Mongo-filter from specified selectors.
+func (r *recallArgs) filter() map[string]interface{} {
+	filter := map[string]interface{}{}
+	if r.Lot != "" {
+		filter["lot"] = r.Lot
+	}
+	if r.Origin != "" {
+		filter["origin"] = r.Origin
+	}
+	if r.SKU != "" {
+		filter["sku"] = r.SKU
+	}
+	if r.UPC != 0 {
+		filter["upc"] = r.UPC
+	}
+	return filter
+}
+
+// notRecalled restricts the filter to shipments which are not recalled.
+func notRecalled(filter map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"status": map[string]interface{}{
+					"$ne": StatusRecalled,
+				},
+			},
+		},
+	}
+}
+
+// Recall handles "recall" service-action. It sets the shipments matching the
+// lot, origin, SKU or UPC selectors to recalled Status, after which these
+// shipments cannot be sold or donated. Shipments which are already recalled
+// are not recalled again.
+func Recall(collection Collection, event *model.Event) *model.KafkaResponse {
+	args := &recallArgs{}
+	err := json.Unmarshal(event.Data, args)
+	if err != nil {
+		err = errors.Wrap(err, "Recall: Error while unmarshalling Event-data")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	filter := args.filter()
+	if len(filter) == 0 {
+		err = errors.New("blank selector provided")
+		err = errors.Wrap(err, "Recall")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if args.RecallRef == "" {
+		err = errors.New("missing RecallRef")
+		err = errors.Wrap(err, "Recall")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	unit, err := weight.ParseUnit(args.WeightUnit)
+	if err != nil {
+		err = errors.Wrap(err, "Recall")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	// Recalled shipments keep the RecallRef and date of their first recall
+	filter = notRecalled(notDeleted(filter))
+	recalled, err := findShipments(collection, filter)
+	if err != nil {
+		err = errors.Wrap(err, "Recall: Error finding shipments")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	// Shipments which no longer match the filter when written are skipped
+	written, err := writeShipments(
+		collection,
+		filter,
+		nil,
+		recalled,
+		map[string]interface{}{
+			"dateRecalled": event.Timestamp.Unix(),
+			"recallRef":    args.RecallRef,
+			"status":       StatusRecalled,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Recall: Error writing shipments")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	// The command succeeded, so errors in publishing are only logged
+	err = publish(event, written.Changes)
+	if err != nil {
+		err = errors.Wrap(err, "Recall")
+		log.Println(err)
+	}
+
+	result := &recallResult{
+		MatchedCount:  int64(len(written.Changes)),
+		ModifiedCount: written.ModifiedCount,
+		Items:         []recalledItem{},
+		WeightUnit:    string(unit),
+	}
+	for _, ship := range written.written() {
+		// The on-hand weight is the remainingWeight stored with the shipment
+		onHandWeight := weight.Convert(ship.remainingWeight(), weight.Canonical, unit)
+		result.Items = append(result.Items, recalledItem{
+			ItemID:       ship.ItemID.String(),
+			OnHandWeight: onHandWeight,
+		})
+		result.OnHandWeight += onHandWeight
+	}
+
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Recall: Error marshalling Shipment Recall-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
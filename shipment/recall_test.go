@@ -0,0 +1,54 @@
+package shipment
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("recall", func() {
+	It("should return error if selector is empty", func() {
+		recallArgs := map[string]interface{}{
+			"serviceAction": "recall",
+			"recallRef":     "test-recall",
+		}
+		mockEvent := newMockEvent("update", recallArgs)
+		kr := Recall(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should return error if recallRef is empty", func() {
+		recallArgs := map[string]interface{}{
+			"serviceAction": "recall",
+			"lot":           "test-lot",
+		}
+		mockEvent := newMockEvent("update", recallArgs)
+		kr := Recall(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should not recall shipments again", func() {
+		filters := []interface{}{}
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				filters = append(filters, filter)
+				return []interface{}{}, nil
+			},
+		}
+
+		kr := Recall(collection, newMockEvent("update", []byte(`{"lot": "A1", "recallRef": "R-2"}`)))
+		Expect(kr.Error).To(BeEmpty())
+		Expect(filters).To(HaveLen(1))
+		guards := filters[0].(map[string]interface{})["$and"].([]interface{})
+		Expect(guards[1]).To(Equal(map[string]interface{}{
+			"status": map[string]interface{}{"$ne": StatusRecalled},
+		}))
+	})
+})
@@ -0,0 +1,208 @@
+package shipment
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/insertopt"
+	"github.com/pkg/errors"
+)
+
+// sessionCollection is a Collection which runs its operations in a Mongo
+// session, so these are part of the session's transaction. Arguments and
+// results are converted as by the mongo.Collection it wraps. Operations are
+// run without a session if the session is nil.
+type sessionCollection struct {
+	collection *mongo.Collection
+	session    *mgo.Session
+	// err is the first error of an operation, after which Mongo aborts the
+	// transaction of the session.
+	err error
+}
+
+// CountDocuments counts the documents matching the filter.
+func (c *sessionCollection) CountDocuments(filter interface{}) (int64, error) {
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		err = errors.Wrap(err, "CountDocuments - BSON Convert Error")
+		return 0, err
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	var count int64
+	if c.session != nil {
+		count, err = c.collection.Collection().CountDocuments(ctx, filterDoc, c.session)
+	} else {
+		count, err = c.collection.Collection().CountDocuments(ctx, filterDoc)
+	}
+	return count, c.failed(err)
+}
+
+// DeleteMany deletes the documents matching the filter.
+func (c *sessionCollection) DeleteMany(filter interface{}) (*mgo.DeleteResult, error) {
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		err = errors.Wrap(err, "DeleteMany - BSON Convert Error")
+		return nil, err
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	var result *mgo.DeleteResult
+	if c.session != nil {
+		result, err = c.collection.Collection().DeleteMany(ctx, filterDoc, c.session)
+	} else {
+		result, err = c.collection.Collection().DeleteMany(ctx, filterDoc)
+	}
+	return result, c.failed(err)
+}
+
+// Find finds the documents matching the filter, decoded into the
+// SchemaStruct of the collection.
+func (c *sessionCollection) Find(
+	filter interface{}, opts ...findopt.Find,
+) ([]interface{}, error) {
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		err = errors.Wrap(err, "Find - BSON Convert Error")
+		return nil, err
+	}
+	if c.session != nil {
+		opts = append(opts, c.session)
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	cur, err := c.collection.Collection().Find(ctx, filterDoc, opts...)
+	if err != nil {
+		err = errors.Wrap(err, "Find Error")
+		return nil, c.failed(err)
+	}
+	defer cur.Close(ctx)
+
+	schemaType := reflect.TypeOf(c.collection.SchemaStruct).Elem()
+	items := []interface{}{}
+	for cur.Next(ctx) {
+		item := reflect.New(schemaType).Interface()
+		err = cur.Decode(item)
+		if err != nil {
+			err = errors.Wrap(err, "Find - Cursor Decode Error")
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	err = cur.Err()
+	if err != nil {
+		err = errors.Wrap(err, "Find - Cursor Error")
+		return nil, c.failed(err)
+	}
+	return items, nil
+}
+
+// InsertMany inserts the documents. Ordered inserts stop at the first failed
+// document, while unordered inserts continue with the remaining documents.
+func (c *sessionCollection) InsertMany(
+	data []interface{}, ordered bool,
+) (*mgo.InsertManyResult, error) {
+	docs := make([]interface{}, len(data))
+	for i, d := range data {
+		doc, err := toDocument(d)
+		if err != nil {
+			err = errors.Wrap(err, "InsertMany - BSON Convert Error")
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	opts := []insertopt.Many{insertopt.Ordered(ordered)}
+	if c.session != nil {
+		opts = append(opts, c.session)
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	result, err := c.collection.Collection().InsertMany(ctx, docs, opts...)
+	return result, c.failed(err)
+}
+
+// InsertOne inserts the document.
+func (c *sessionCollection) InsertOne(data interface{}) (*mgo.InsertOneResult, error) {
+	doc, err := toDocument(data)
+	if err != nil {
+		err = errors.Wrap(err, "InsertOne - BSON Convert Error")
+		return nil, err
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	var result *mgo.InsertOneResult
+	if c.session != nil {
+		result, err = c.collection.Collection().InsertOne(ctx, doc, c.session)
+	} else {
+		result, err = c.collection.Collection().InsertOne(ctx, doc)
+	}
+	return result, c.failed(err)
+}
+
+// UpdateMany sets the fields of update in the documents matching the filter.
+func (c *sessionCollection) UpdateMany(
+	filter interface{}, update interface{},
+) (*mgo.UpdateResult, error) {
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		err = errors.Wrap(err, "UpdateMany - BSON Convert Error for filter-argument")
+		return nil, err
+	}
+	setDoc, err := toDocument(update)
+	if err != nil {
+		err = errors.Wrap(err, "UpdateMany - BSON Convert Error for update-argument")
+		return nil, err
+	}
+	updateDoc := bson.NewDocument(bson.EC.SubDocument("$set", setDoc))
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	var result *mgo.UpdateResult
+	if c.session != nil {
+		result, err = c.collection.Collection().UpdateMany(ctx, filterDoc, updateDoc, c.session)
+	} else {
+		result, err = c.collection.Collection().UpdateMany(ctx, filterDoc, updateDoc)
+	}
+	return result, c.failed(err)
+}
+
+// failed records the error of an operation, if it is the first, and returns it.
+func (c *sessionCollection) failed(err error) error {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+// timeoutContext returns the context for an operation, which times out after
+// the resource-timeout of the connection.
+func (c *sessionCollection) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(c.collection.Connection.Timeout) * time.Millisecond
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// toDocument converts the data to a BSON document. A zero ObjectID is removed,
+// so Mongo generates one instead.
+func toDocument(data interface{}) (*bson.Document, error) {
+	doc, err := bson.NewDocumentEncoder().EncodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := doc.Lookup("_id")
+	if id != nil && id.Type() == bson.TypeObjectID && id.ObjectID() == objectid.NilObjectID {
+		doc.Delete("_id")
+	}
+	return doc, nil
+}
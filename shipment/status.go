@@ -0,0 +1,72 @@
+package shipment
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// blockedStatuses are the Statuses in which shipments cannot be sold or donated.
+var blockedStatuses = []string{StatusHeld, StatusRecalled}
+
+// saleFields are the fields modified when selling or donating shipments.
+var saleFields = []string{"dateSold", "donateWeight", "salePrice", "soldWeight"}
+
+// isSaleUpdate checks if the update sells or donates shipments.
+func isSaleUpdate(update map[string]interface{}) bool {
+	for _, field := range saleFields {
+		if _, exists := update[field]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// saleFilter restricts the filter to shipments that can be sold or donated.
+func saleFilter(filter map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"status": map[string]interface{}{
+					"$nin": blockedStatuses,
+				},
+			},
+		},
+	}
+}
+
+// findBlocked finds the shipments matching the filter which cannot be sold
+// or donated.
+func findBlocked(
+	collection Collection, filter map[string]interface{},
+) ([]*Shipment, error) {
+	blockedFilter := map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"status": map[string]interface{}{
+					"$in": blockedStatuses,
+				},
+			},
+		},
+	}
+	blocked, err := findShipments(collection, blockedFilter)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding blocked shipments")
+		return nil, err
+	}
+	return blocked, nil
+}
+
+// blockedError describes the shipments which cannot be sold or donated.
+func blockedError(blocked []*Shipment) error {
+	desc := make([]string, len(blocked))
+	for i, ship := range blocked {
+		desc[i] = fmt.Sprintf("%s (%s)", ship.ItemID, ship.Status)
+	}
+	return errors.Errorf(
+		"shipments cannot be sold or donated: %s", strings.Join(desc, ", "),
+	)
+}
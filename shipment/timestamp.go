@@ -0,0 +1,122 @@
+package shipment
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// millisThreshold is the smallest absolute unix-timestamp read as milliseconds.
+// As seconds, it would be in year 5138; while as milliseconds, it is in 1973.
+const millisThreshold = 1e11
+
+// Valid range for timestamps, outside which they are considered erroneous.
+var (
+	minTimestamp = time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC).Unix()
+	maxTimestamp = time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+)
+
+// dateFields are the Shipment fields which contain timestamps.
+var dateFields = codecNames(timestampField, dateField)
+
+// dateOnlyFields are the dateFields which also accept dates without time.
+var dateOnlyFields = fieldSet(codecNames(dateField))
+
+// fieldSet returns the set of specified fields.
+func fieldSet(fields []string) map[string]bool {
+	set := map[string]bool{}
+	for _, field := range fields {
+		set[field] = true
+	}
+	return set
+}
+
+// assertTimestamp converts the value to unix-seconds. Accepted values are
+// RFC3339 strings, unix seconds or milliseconds (detected by magnitude), and
+// also dates ("2006-01-02", as UTC) if dateOnly is true.
+func assertTimestamp(value interface{}, dateOnly bool) (int64, error) {
+	var ts int64
+	switch v := value.(type) {
+	case int:
+		ts = fromUnix(float64(v))
+	case int32:
+		ts = fromUnix(float64(v))
+	case int64:
+		ts = fromUnix(float64(v))
+	case float64:
+		ts = fromUnix(v)
+	case string:
+		t, err := parseTimeString(v, dateOnly)
+		if err != nil {
+			return 0, err
+		}
+		ts = t
+	default:
+		return 0, errors.Errorf("expected timestamp, got %T", value)
+	}
+
+	// Zero denotes an unset timestamp, as rendered by MarshalJSON
+	if ts == 0 {
+		return 0, nil
+	}
+	if ts < minTimestamp || ts >= maxTimestamp {
+		return 0, errors.Errorf(
+			"timestamp %s is out of range",
+			time.Unix(ts, 0).UTC().Format(time.RFC3339),
+		)
+	}
+	return ts, nil
+}
+
+// fromUnix converts unix seconds or milliseconds to seconds.
+func fromUnix(value float64) int64 {
+	if math.Abs(value) >= millisThreshold {
+		return int64(value / 1000)
+	}
+	return int64(value)
+}
+
+func parseTimeString(value string, dateOnly bool) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return fromUnix(float64(n)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t.Unix(), nil
+	}
+	if dateOnly {
+		t, err = time.Parse("2006-01-02", value)
+		if err == nil {
+			return t.Unix(), nil
+		}
+		return 0, errors.Errorf(
+			"expected RFC3339 time or date (YYYY-MM-DD): %s", value,
+		)
+	}
+	return 0, errors.Errorf("expected RFC3339 time: %s", value)
+}
+
+// validateUpdateDates converts the timestamps in update to unix-seconds.
+func validateUpdateDates(update map[string]interface{}) ValidationErrors {
+	verrs := ValidationErrors{}
+	for _, field := range dateFields {
+		if update[field] == nil {
+			continue
+		}
+		ts, err := assertTimestamp(update[field], dateOnlyFields[field])
+		if err != nil {
+			verrs = append(verrs, FieldError{field, err.Error()})
+			continue
+		}
+		update[field] = ts
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
@@ -0,0 +1,39 @@
+package shipment
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("timestamps", func() {
+	It("should accept RFC3339, dates, and unix seconds or milliseconds", func() {
+		expected := time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC).Unix()
+		ship := &Shipment{}
+		err := json.Unmarshal([]byte(`{
+			"dateArrived": "2026-10-30T00:00:00Z",
+			"dateSold": 1793318400000,
+			"expiryDate": "2026-10-30",
+			"timestamp": 1793318400
+		}`), ship)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ship.DateArrived).To(Equal(expected))
+		Expect(ship.DateSold).To(Equal(expected))
+		Expect(ship.ExpiryDate).To(Equal(expected))
+		Expect(ship.Timestamp).To(Equal(expected))
+	})
+
+	It("should return error if timestamp is out of range", func() {
+		ship := &Shipment{}
+		err := json.Unmarshal([]byte(`{"dateArrived": 1793318400000000}`), ship)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return error if date-only value is used for non-expiry fields", func() {
+		ship := &Shipment{}
+		err := json.Unmarshal([]byte(`{"dateArrived": "2026-10-30"}`), ship)
+		Expect(err).To(HaveOccurred())
+	})
+})
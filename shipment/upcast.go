@@ -0,0 +1,52 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/pkg/errors"
+)
+
+// Upcaster converts Event-data from an older version of its payload to the
+// next version, such as by renaming or restructuring fields.
+type Upcaster func(data []byte) ([]byte, error)
+
+// upcasterKey is the Event-Action and Version of the Event-data
+// converted by an Upcaster.
+type upcasterKey struct {
+	Action  string
+	Version int64
+}
+
+// upcasters are the registered Upcasters. Event-data is upcasted by applying
+// the Upcaster for its version, and then the Upcaster for each next version,
+// until no Upcaster is registered for the version reached. Events without
+// any Upcasters for their version are hence handled as-is.
+var upcasters = map[upcasterKey]Upcaster{}
+
+// RegisterUpcaster registers the Upcaster converting the Event-data of action
+// from version to the next version. Upcasters should be registered before
+// Events are handled, since the registry is not safe for concurrent writes.
+func RegisterUpcaster(action string, version int64, upcaster Upcaster) {
+	upcasters[upcasterKey{action, version}] = upcaster
+}
+
+// upcast returns a copy of the Event, with its Data and Version upcasted
+// to the latest version of the payload.
+func upcast(event *model.Event) (*model.Event, error) {
+	upcasted := *event
+	for {
+		upcaster := upcasters[upcasterKey{upcasted.Action, upcasted.Version}]
+		if upcaster == nil {
+			return &upcasted, nil
+		}
+		data, err := upcaster(upcasted.Data)
+		if err != nil {
+			err = errors.Wrapf(
+				err, "Error upcasting %s Event-data from version %d",
+				upcasted.Action, upcasted.Version,
+			)
+			return nil, err
+		}
+		upcasted.Data = data
+		upcasted.Version++
+	}
+}
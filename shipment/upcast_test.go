@@ -0,0 +1,77 @@
+package shipment
+
+import (
+	"strings"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("upcasters", func() {
+	AfterEach(func() {
+		upcasters = map[upcasterKey]Upcaster{}
+	})
+
+	It("should upcast Event-data through each next version", func() {
+		RegisterUpcaster("update", 1, func(data []byte) ([]byte, error) {
+			return []byte(strings.Replace(string(data), "lotNumber", "lotNo", 1)), nil
+		})
+		RegisterUpcaster("update", 2, func(data []byte) ([]byte, error) {
+			return []byte(strings.Replace(string(data), "lotNo", "lot", 1)), nil
+		})
+		event := &model.Event{
+			Action:  "update",
+			Data:    []byte(`{"lotNumber":"A1"}`),
+			Version: 1,
+		}
+
+		upcasted, err := upcast(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(upcasted.Data)).To(Equal(`{"lot":"A1"}`))
+		Expect(upcasted.Version).To(Equal(int64(3)))
+		// The original Event is not modified
+		Expect(string(event.Data)).To(Equal(`{"lotNumber":"A1"}`))
+		Expect(event.Version).To(Equal(int64(1)))
+
+		event.Version = 2
+		upcasted, err = upcast(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(upcasted.Data)).To(Equal(`{"lotNumber":"A1"}`))
+		Expect(upcasted.Version).To(Equal(int64(3)))
+
+		event.Action = "insert"
+		upcasted, err = upcast(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(upcasted.Version).To(Equal(int64(2)))
+	})
+
+	It("should route upcasted Events", func() {
+		RegisterUpcaster("update", 1, func(data []byte) ([]byte, error) {
+			return []byte(`{"serviceAction":"recall"}`), nil
+		})
+		mockEvent := newMockEvent("update", []byte(`{"serviceAction":"recallLot"}`))
+		mockEvent.Version = 1
+		kr := Handle(nil, mockEvent)
+		Expect(kr.Error).To(ContainSubstring("Recall"))
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should return error if upcasting fails", func() {
+		RegisterUpcaster("insert", 1, func(data []byte) ([]byte, error) {
+			return nil, errors.New("missing lotNumber")
+		})
+		mockEvent := newMockEvent("insert", []byte(`{}`))
+		mockEvent.Version = 1
+		kr := Handle(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).To(ContainSubstring(
+			"Error upcasting insert Event-data from version 1: missing lotNumber",
+		))
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+})
@@ -7,22 +7,47 @@ import (
 	"github.com/TerrexTech/uuuid"
 
 	"github.com/TerrexTech/go-eventstore-models/model"
-	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/pkg/errors"
 )
 
+// MaxReturned is the maximum number of shipments returned in an Update-result.
+var MaxReturned = 50
+
 type shipmentUpdate struct {
+	commandFlags
 	Filter map[string]interface{} `json:"filter"`
 	Update map[string]interface{} `json:"update"`
+	// ReturnAfter includes the updated shipments in Update-result.
+	ReturnAfter bool `json:"returnAfter,omitempty"`
+	// ReturnBefore includes the shipments, as before the update, in Update-result.
+	ReturnBefore bool `json:"returnBefore,omitempty"`
 }
 
 type updateResult struct {
-	MatchedCount  int64 `json:"matchedCount,omitempty"`
-	ModifiedCount int64 `json:"modifiedCount,omitempty"`
+	MatchedCount  int64       `json:"matchedCount,omitempty"`
+	ModifiedCount int64       `json:"modifiedCount,omitempty"`
+	Before        []*Shipment `json:"before,omitempty"`
+	After         []*Shipment `json:"after,omitempty"`
+	// Truncated is true if only the first MaxReturned shipments are returned.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// capReturned limits the shipments to MaxReturned, and reports
+// if any shipments were left out.
+func capReturned(ships []*Shipment) ([]*Shipment, bool) {
+	if len(ships) <= MaxReturned {
+		return ships, false
+	}
+	return ships[:MaxReturned], true
 }
 
-// Update handles "update" events.
-func Update(collection *mongo.Collection, event *model.Event) *model.KafkaResponse {
+// Update handles "update" events. Event-data containing a JSON Patch or
+// JSON Merge Patch is applied to a single shipment using PatchUpdate.
+func Update(collection Collection, event *model.Event) *model.KafkaResponse {
+	if isPatchUpdate(event.Data) {
+		return PatchUpdate(collection, event)
+	}
+
 	shipUpdate := &shipmentUpdate{}
 
 	err := json.Unmarshal(event.Data, shipUpdate)
@@ -50,6 +75,8 @@ func Update(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 			UUID:          event.TimeUUID,
 		}
 	}
+	// DigitalLink is derived from other fields, and is not stored
+	delete(shipUpdate.Update, "digitalLink")
 	if len(shipUpdate.Update) == 0 {
 		err = errors.New("blank update provided")
 		err = errors.Wrap(err, "Update")
@@ -75,9 +102,161 @@ func Update(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		}
 	}
 
-	updateStats, err := collection.UpdateMany(shipUpdate.Filter, shipUpdate.Update)
+	// Filters and updates are restricted to the allowed fields and operators
+	verrs := checkFilter(shipUpdate.Filter)
+	verrs = append(verrs, checkUpdatePolicy(shipUpdate.Update)...)
+	if len(verrs) > 0 {
+		err = errors.Wrap(verrs, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	verrs = checkPreconditions(shipUpdate.Preconditions)
+	if verrs != nil {
+		err = errors.Wrap(verrs, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	verrs = checkFields(shipUpdate.Update)
+	if verrs != nil {
+		err = errors.Wrap(verrs, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	verrs = validateUpdate(shipUpdate.Update)
+	if verrs != nil {
+		err = errors.Wrap(verrs, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	filter := notDeleted(shipUpdate.Filter)
+	if isSaleUpdate(shipUpdate.Update) {
+		blocked, err := findBlocked(collection, filter)
+		if err != nil {
+			err = errors.Wrap(err, "Update")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		if len(blocked) > 0 {
+			err = errors.Wrap(blockedError(blocked), "Update")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     InvalidStateError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		// Guards against shipments getting blocked after the check above
+		filter = saleFilter(filter)
+	}
+
+	affected, err := shipUpdate.findAffected(collection, filter)
+	if err != nil {
+		err = errors.Wrap(err, "Update: Error finding affected shipments")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	if shipUpdate.DryRun {
+		return shipUpdate.dryRunResponse("Update", event, collection, filter, affected)
+	}
+	err = shipUpdate.checkLimit(len(affected))
+	if err != nil {
+		err = errors.Wrap(err, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     LimitExceededError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	verrs = checkMutability(shipUpdate.Update, affected)
+	verrs = append(verrs, checkBarcodeUpdate(shipUpdate.Update, affected)...)
+	verrs = append(verrs, checkCurrencyUpdate(shipUpdate.Update, affected)...)
+	verrs = append(verrs, checkStoredUpdate(shipUpdate.Update, affected)...)
+	if len(verrs) > 0 {
+		err = errors.Wrap(verrs, "Update")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     ValidationError,
+			UUID:          event.TimeUUID,
+		}
+	}
+	filter = limitFilter(filter, affected)
+	filter = mutabilityFilter(filter, shipUpdate.Update)
+	filter = barcodeFilter(filter, shipUpdate.Update)
+	filter = currencyFilter(filter, shipUpdate.Update)
+
+	preconditions := shipUpdate.Preconditions
+	if len(preconditions) > 0 {
+		failed, err := findFailedPreconditions(collection, filter, preconditions)
+		if err != nil {
+			err = errors.Wrap(err, "Update")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		if len(failed) > 0 {
+			return preconditionResponse("Update", event, &preconditionResult{
+				Failed: preconditionFailures(failed, preconditions),
+			})
+		}
+	}
+
+	// Shipments are written separately, so the shipments which are written
+	// and which fail the preconditions are known
+	written, err := writeShipments(
+		collection, filter, preconditions, affected, shipUpdate.Update,
+	)
 	if err != nil {
-		err = errors.Wrap(err, "Update: Error in UpdateMany")
+		err = errors.Wrap(err, "Update: Error writing shipments")
 		log.Println(err)
 		return &model.KafkaResponse{
 			AggregateID:   event.AggregateID,
@@ -88,9 +267,37 @@ func Update(collection *mongo.Collection, event *model.Event) *model.KafkaRespon
 		}
 	}
 
+	// The written shipments stay written even if others fail, so their
+	// DomainEvents are published either way. Errors in publishing are only
+	// logged, since the shipments were written.
+	err = publish(event, written.Changes)
+	if err != nil {
+		err = errors.Wrap(err, "Update")
+		log.Println(err)
+	}
+
+	// Shipments modified after the preconditions were checked are not written
+	if len(written.Failed) > 0 {
+		return preconditionResponse("Update", event, &preconditionResult{
+			MatchedCount:  int64(len(written.Changes)),
+			ModifiedCount: written.ModifiedCount,
+			Failed:        preconditionFailures(written.Failed, preconditions),
+		})
+	}
+
 	result := &updateResult{
-		MatchedCount:  updateStats.MatchedCount,
-		ModifiedCount: updateStats.ModifiedCount,
+		MatchedCount:  int64(len(written.Changes)),
+		ModifiedCount: written.ModifiedCount,
+	}
+	if shipUpdate.ReturnBefore {
+		before, truncated := capReturned(written.before())
+		result.Before = before
+		result.Truncated = truncated
+	}
+	if shipUpdate.ReturnAfter {
+		after, truncated := capReturned(written.written())
+		result.After = after
+		result.Truncated = result.Truncated || truncated
 	}
 	resultMarshal, err := json.Marshal(result)
 	if err != nil {
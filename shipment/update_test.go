@@ -0,0 +1,96 @@
+package shipment
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("update result", func() {
+	It("should cap returned shipments to MaxReturned", func() {
+		ships := make([]*Shipment, MaxReturned+1)
+		for i := range ships {
+			ships[i] = &Shipment{}
+		}
+		returned, truncated := capReturned(ships)
+		Expect(returned).To(HaveLen(MaxReturned))
+		Expect(truncated).To(BeTrue())
+
+		returned, truncated = capReturned(ships[:MaxReturned])
+		Expect(returned).To(HaveLen(MaxReturned))
+		Expect(truncated).To(BeFalse())
+	})
+
+	It("should render before and after shipments", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		result := &updateResult{
+			MatchedCount:  1,
+			ModifiedCount: 1,
+			Before:        []*Shipment{&Shipment{ItemID: itemID, Lot: "A1"}},
+			After:         []*Shipment{&Shipment{ItemID: itemID, Lot: "B2"}},
+		}
+		marshalResult, err := json.Marshal(result)
+		Expect(err).ToNot(HaveOccurred())
+
+		unmarshalResult := map[string]interface{}{}
+		err = json.Unmarshal(marshalResult, &unmarshalResult)
+		Expect(err).ToNot(HaveOccurred())
+		before := unmarshalResult["before"].([]interface{})[0].(map[string]interface{})
+		after := unmarshalResult["after"].([]interface{})[0].(map[string]interface{})
+		Expect(before["lot"]).To(Equal("A1"))
+		Expect(after["lot"]).To(Equal("B2"))
+		Expect(after["itemID"]).To(Equal(itemID.String()))
+		Expect(unmarshalResult).ToNot(HaveKey("truncated"))
+	})
+
+	It("should return only the written shipments as before the update", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		written := &Shipment{ID: objectid.New(), ItemID: itemID, Lot: "A1"}
+		// The other shipment no longer matches the filter when written
+		skipped := &Shipment{ID: objectid.New(), ItemID: otherID, Lot: "A1"}
+
+		finds := 0
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				finds++
+				if finds == 1 {
+					return []interface{}{written, skipped}, nil
+				}
+				return []interface{}{}, nil
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				guards := filter.(map[string]interface{})["$and"].([]interface{})
+				if guards[1].(map[string]interface{})["_id"] != written.ID {
+					return &mgo.UpdateResult{}, nil
+				}
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		}
+
+		kr := Update(collection, newMockEvent("update", []byte(`{
+			"filter": {"lot": "A1"},
+			"update": {"sku": "S1"},
+			"returnBefore": true,
+			"returnAfter": true
+		}`)))
+		Expect(kr.Error).To(BeEmpty())
+
+		result := &updateResult{}
+		err = json.Unmarshal(kr.Result, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.MatchedCount).To(Equal(int64(1)))
+		Expect(result.Before).To(HaveLen(1))
+		Expect(result.Before[0].ItemID).To(Equal(itemID))
+		Expect(result.Before[0].SKU).To(BeEmpty())
+		Expect(result.After).To(HaveLen(1))
+		Expect(result.After[0].SKU).To(Equal("S1"))
+	})
+})
@@ -0,0 +1,275 @@
+package shipment
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// gs1Fields are the Shipment fields which can be filled from GS1 data.
+var gs1Fields = []string{"barcode", "expiryDate", "lot", "totalWeight"}
+
+const (
+	upsertInserted = "inserted"
+	upsertMerged   = "merged"
+)
+
+type upsertResult struct {
+	// Operation is "inserted" if the shipment was new, or "merged" if the
+	// provided fields were merged into the existing shipment.
+	Operation string    `json:"operation"`
+	Shipment  *Shipment `json:"shipment"`
+}
+
+// mergeUpdate creates the update for merging the upserted shipment into the
+// existing shipment. Only the fields provided in Event-data, and the non-zero
+// fields filled from GS1 data, are merged.
+func mergeUpdate(ship *Shipment, fields map[string]interface{}) map[string]interface{} {
+	merged := []string{}
+	for _, key := range sortedKeys(fields) {
+		if key != "_id" && key != "itemID" && isKnownField(key) {
+			merged = append(merged, key)
+		}
+	}
+	update := ship.storedFields(merged)
+	// Prices are stored in minor units of their currency, so it is merged along
+	if update["price"] != nil || update["salePrice"] != nil {
+		update["currency"] = ship.currency()
+	}
+
+	if fields["gs1"] != nil || fields["digitalLink"] != nil {
+		gs1Values := ship.storedFields(gs1Fields)
+		for field, value := range gs1Values {
+			if _, provided := update[field]; provided {
+				continue
+			}
+			if !isZero(value) {
+				update[field] = value
+			}
+		}
+	}
+	return update
+}
+
+// Upsert handles "upsert" service-action. It inserts the shipment if no shipment
+// exists with its ItemID, or merges the provided fields into the existing shipment.
+// Devices can hence safely resend shipment registrations.
+func Upsert(collection Collection, event *model.Event) *model.KafkaResponse {
+	ship, err := parseInsert(event.Data)
+	if err != nil {
+		err = errors.Wrap(err, "Upsert")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     validationCode(err, InternalError),
+			UUID:          event.TimeUUID,
+		}
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(event.Data, &fields)
+	if err != nil {
+		err = errors.Wrap(err, "Upsert: Error while unmarshalling Event-data fields")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	// Soft-deleted shipments are also found, since these still hold the ItemID
+	existing, err := findShipments(collection, map[string]interface{}{
+		"itemID": ship.ItemID.String(),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Upsert: Error finding existing shipment")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     DatabaseError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	result := &upsertResult{}
+	if len(existing) == 0 {
+		// The lookup runs in the transaction the Event is handled in, so a
+		// concurrent upsert inserting the shipment conflicts with this one,
+		// and the Event is handled again
+		insertResult, err := collection.InsertOne(ship)
+		if err != nil {
+			err = errors.Wrap(err, "Upsert: Error Inserting shipment into Mongo")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     DatabaseError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		insertedID, assertOK := insertResult.InsertedID.(objectid.ObjectID)
+		if !assertOK {
+			err = errors.New("error asserting InsertedID from InsertResult to ObjectID")
+			err = errors.Wrap(err, "Upsert")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     InternalError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		ship.ID = insertedID
+		// The command succeeded, so errors in publishing are only logged
+		err = publishCreated(event, []*Shipment{ship})
+		if err != nil {
+			err = errors.Wrap(err, "Upsert")
+			log.Println(err)
+		}
+		result.Operation = upsertInserted
+		result.Shipment = ship
+	}
+	if len(existing) > 0 {
+		current := existing[0]
+		if current.DeletedAt != 0 {
+			err = errors.Errorf(
+				"shipment with ItemID %s is deleted, and must be restored first",
+				ship.ItemID,
+			)
+			err = errors.Wrap(err, "Upsert")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     InvalidStateError,
+				UUID:          event.TimeUUID,
+			}
+		}
+
+		// Prices without a currency are read in the shipment's currency
+		if fields["currency"] == nil {
+			err = ship.readPrices(fields, current.currency())
+			if err != nil {
+				err = errors.Wrap(err, "Upsert")
+				log.Println(err)
+				return &model.KafkaResponse{
+					AggregateID:   event.AggregateID,
+					CorrelationID: event.CorrelationID,
+					Error:         err.Error(),
+					ErrorCode:     ValidationError,
+					UUID:          event.TimeUUID,
+				}
+			}
+		}
+		update := mergeUpdate(ship, fields)
+		verrs := checkMutability(update, []*Shipment{current})
+		verrs = append(verrs, checkBarcodeUpdate(update, []*Shipment{current})...)
+		verrs = append(verrs, checkCurrencyUpdate(update, []*Shipment{current})...)
+		if len(verrs) > 0 {
+			err = errors.Wrap(verrs, "Upsert")
+			log.Println(err)
+			return &model.KafkaResponse{
+				AggregateID:   event.AggregateID,
+				CorrelationID: event.CorrelationID,
+				Error:         err.Error(),
+				ErrorCode:     ValidationError,
+				UUID:          event.TimeUUID,
+			}
+		}
+		filter := notDeleted(map[string]interface{}{
+			"itemID": ship.ItemID.String(),
+		})
+		filter = mutabilityFilter(filter, update)
+		filter = barcodeFilter(filter, update)
+		filter = currencyFilter(filter, update)
+		if isSaleUpdate(update) {
+			if current.Status == StatusHeld || current.Status == StatusRecalled {
+				err = errors.Wrap(blockedError([]*Shipment{current}), "Upsert")
+				log.Println(err)
+				return &model.KafkaResponse{
+					AggregateID:   event.AggregateID,
+					CorrelationID: event.CorrelationID,
+					Error:         err.Error(),
+					ErrorCode:     InvalidStateError,
+					UUID:          event.TimeUUID,
+				}
+			}
+			filter = saleFilter(filter)
+		}
+
+		// The merged shipment is current, unless the merge changes fields
+		merged := current
+		if len(update) > 0 {
+			written, err := writeShipments(
+				collection, filter, nil, []*Shipment{current}, update,
+			)
+			if err != nil {
+				err = errors.Wrap(err, "Upsert: Error writing merged shipment")
+				log.Println(err)
+				return &model.KafkaResponse{
+					AggregateID:   event.AggregateID,
+					CorrelationID: event.CorrelationID,
+					Error:         err.Error(),
+					ErrorCode:     DatabaseError,
+					UUID:          event.TimeUUID,
+				}
+			}
+			if len(written.Changes) == 0 {
+				err = errors.Errorf(
+					"shipment with ItemID %s was modified during upsert", ship.ItemID,
+				)
+				err = errors.Wrap(err, "Upsert")
+				log.Println(err)
+				return &model.KafkaResponse{
+					AggregateID:   event.AggregateID,
+					CorrelationID: event.CorrelationID,
+					Error:         err.Error(),
+					ErrorCode:     InvalidStateError,
+					UUID:          event.TimeUUID,
+				}
+			}
+			merged = written.Changes[0].After
+
+			// The command succeeded, so errors in publishing are only logged
+			err = publish(event, written.Changes)
+			if err != nil {
+				err = errors.Wrap(err, "Upsert")
+				log.Println(err)
+			}
+		}
+		result.Operation = upsertMerged
+		result.Shipment = merged
+	}
+
+	resultMarshal, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Upsert: Error marshalling Shipment Upsert-result")
+		log.Println(err)
+		return &model.KafkaResponse{
+			AggregateID:   event.AggregateID,
+			CorrelationID: event.CorrelationID,
+			Error:         err.Error(),
+			ErrorCode:     InternalError,
+			UUID:          event.TimeUUID,
+		}
+	}
+
+	return &model.KafkaResponse{
+		AggregateID:   event.AggregateID,
+		CorrelationID: event.CorrelationID,
+		Result:        resultMarshal,
+		UUID:          event.TimeUUID,
+	}
+}
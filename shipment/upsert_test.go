@@ -0,0 +1,100 @@
+package shipment
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/go-eventstore-models/model"
+	"github.com/TerrexTech/uuuid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Upsert", func() {
+	It("should fail without merging if inserting the shipment fails", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+
+		finds := 0
+		updates := 0
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				finds++
+				return []interface{}{}, nil
+			},
+			insertOne: func(data interface{}) (*mgo.InsertOneResult, error) {
+				// Failed writes abort the transaction, so the shipment
+				// cannot be looked up again
+				err := mgo.WriteErrors{
+					mgo.WriteError{Code: duplicateKeyCode, Message: "duplicate key"},
+				}
+				return nil, errors.Wrap(err, "InsertOne Error")
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				updates++
+				return &mgo.UpdateResult{MatchedCount: 1}, nil
+			},
+		}
+
+		mockEvent := newMockEvent("insert", map[string]interface{}{
+			"itemID":        itemID.String(),
+			"lot":           "B2",
+			"serviceAction": "upsert",
+		})
+		kr := Upsert(collection, mockEvent)
+		Expect(kr.ErrorCode).To(Equal(int16(DatabaseError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(finds).To(Equal(1))
+		Expect(updates).To(BeZero())
+	})
+
+	It("should merge only the provided fields, as stored", func() {
+		data := []byte(`{
+			"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e",
+			"price": "13.40",
+			"totalWeight": 2,
+			"weightUnit": "lb",
+			"serviceAction": "upsert"
+		}`)
+		ship, err := parseInsert(data)
+		Expect(err).ToNot(HaveOccurred())
+		fields := map[string]interface{}{}
+		err = json.Unmarshal(data, &fields)
+		Expect(err).ToNot(HaveOccurred())
+
+		update := mergeUpdate(ship, fields)
+		Expect(update).To(HaveLen(4))
+		Expect(update["currency"]).To(Equal(DefaultCurrency))
+		Expect(update["price"]).To(Equal(int64(1340)))
+		Expect(update["totalWeight"]).To(BeNumerically("~", 0.907, 0.001))
+		Expect(update["weightUnit"]).To(Equal("lb"))
+	})
+
+	It("should merge the non-zero fields filled from GS1 data", func() {
+		data := []byte(`{
+			"itemID": "d1c3d3a6-5a3c-4b8e-9f5e-1b8b5c8a9c1e",
+			"gs1": "(01)09501101530003(10)A1"
+		}`)
+		ship, err := parseInsert(data)
+		Expect(err).ToNot(HaveOccurred())
+		fields := map[string]interface{}{}
+		err = json.Unmarshal(data, &fields)
+		Expect(err).ToNot(HaveOccurred())
+
+		update := mergeUpdate(ship, fields)
+		Expect(update).To(Equal(map[string]interface{}{
+			"barcode": "09501101530003",
+			"lot":     "A1",
+		}))
+	})
+
+	It("should route upsert service-action", func() {
+		kr := Handle(nil, &model.Event{
+			Action: "insert",
+			Data:   []byte(`{"serviceAction": "upsert"}`),
+		})
+		Expect(kr.Error).To(ContainSubstring("Upsert: missing ItemID"))
+		Expect(kr.ErrorCode).To(Equal(int16(InternalError)))
+	})
+})
@@ -0,0 +1,233 @@
+package shipment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TerrexTech/agg-shipment-cmd/barcode"
+	util "github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/pkg/errors"
+)
+
+// FieldError is a validation-error for a Shipment field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (f FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", f.Field, f.Message)
+}
+
+// ValidationErrors are the validation-errors for one or more Shipment fields.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, f := range v {
+		msgs[i] = f.Error()
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// validationCode returns the ValidationError code if the error was caused by
+// ValidationErrors, or the provided default code otherwise.
+func validationCode(err error, defaultCode int16) int16 {
+	if _, isValidation := errors.Cause(err).(ValidationErrors); isValidation {
+		return ValidationError
+	}
+	return defaultCode
+}
+
+// validate validates the Shipment fields, normalizing them where required.
+func (i *Shipment) validate() ValidationErrors {
+	verrs := ValidationErrors{}
+	verrs = append(verrs, i.validateBarcode()...)
+	verrs = append(verrs, i.validateMoney()...)
+	verrs = append(verrs, validateAttributes(i.Attributes)...)
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// validateUpdate validates the fields in update, normalizing them where required.
+func validateUpdate(update map[string]interface{}) ValidationErrors {
+	verrs := ValidationErrors{}
+	verrs = append(verrs, validateUpdateBarcode(update)...)
+	verrs = append(verrs, validateUpdateDates(update)...)
+	verrs = append(verrs, validateUpdateMoney(update)...)
+	verrs = append(verrs, validateUpdateWeights(update)...)
+	verrs = append(verrs, validateUpdateAttributes(update)...)
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// validateBarcode validates and normalizes the Barcode to GTIN-14,
+// and checks if it matches the UPC.
+func (i *Shipment) validateBarcode() ValidationErrors {
+	verrs := ValidationErrors{}
+
+	var barcodeGTIN, upcGTIN string
+	var err error
+	if i.Barcode != "" {
+		barcodeGTIN, err = barcode.Normalize(i.Barcode)
+		if err != nil {
+			verrs = append(verrs, FieldError{"barcode", err.Error()})
+		} else {
+			i.Barcode = barcodeGTIN
+		}
+	}
+	if i.UPC != 0 {
+		upcGTIN, err = barcode.FromInt(i.UPC)
+		if err != nil {
+			verrs = append(verrs, FieldError{"upc", err.Error()})
+		}
+	}
+	if barcodeGTIN != "" && upcGTIN != "" && barcodeGTIN != upcGTIN {
+		verrs = append(verrs, FieldError{
+			"upc",
+			fmt.Sprintf("does not match barcode %s", barcodeGTIN),
+		})
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// validateUpdateBarcode validates and normalizes the Barcode in update to GTIN-14.
+// The Barcode and UPC are checked to match if both are being updated, while
+// checkBarcodeUpdate checks updates of either against the shipments.
+func validateUpdateBarcode(update map[string]interface{}) ValidationErrors {
+	ship := &Shipment{}
+	if update["barcode"] != nil {
+		code, assertOK := update["barcode"].(string)
+		if !assertOK {
+			return ValidationErrors{
+				FieldError{"barcode", "must be a string"},
+			}
+		}
+		ship.Barcode = code
+	}
+	if update["upc"] != nil {
+		upc, err := util.AssertInt64(update["upc"])
+		if err != nil {
+			return ValidationErrors{
+				FieldError{"upc", "must be an integer"},
+			}
+		}
+		ship.UPC = upc
+	}
+
+	verrs := ship.validateBarcode()
+	if verrs != nil {
+		return verrs
+	}
+	if ship.Barcode != "" {
+		update["barcode"] = ship.Barcode
+	}
+	return nil
+}
+
+// checkBarcodeUpdate checks if the Barcode or UPC in the validated update
+// matches the stored UPC or Barcode of each of the shipments, when only one
+// of these is updated. Shipments without the counterpart are not checked.
+func checkBarcodeUpdate(update map[string]interface{}, ships []*Shipment) ValidationErrors {
+	code, hasBarcode := update["barcode"].(string)
+	upc, upcErr := util.AssertInt64(update["upc"])
+	hasUPC := update["upc"] != nil && upcErr == nil
+	if hasBarcode == hasUPC {
+		return nil
+	}
+
+	mismatched := []string{}
+	for _, ship := range ships {
+		merged := &Shipment{
+			Barcode: ship.Barcode,
+			UPC:     ship.UPC,
+		}
+		if hasBarcode {
+			merged.Barcode = code
+		} else {
+			merged.UPC = upc
+		}
+		if merged.Barcode == "" || merged.UPC == 0 {
+			continue
+		}
+		barcodeGTIN, err := barcode.Normalize(merged.Barcode)
+		if err != nil {
+			continue
+		}
+		upcGTIN, err := barcode.FromInt(merged.UPC)
+		if err != nil || barcodeGTIN != upcGTIN {
+			mismatched = append(mismatched, ship.ItemID.String())
+		}
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+
+	if hasBarcode {
+		return ValidationErrors{FieldError{
+			"barcode",
+			fmt.Sprintf("does not match stored upc of shipments: %v", mismatched),
+		}}
+	}
+	return ValidationErrors{FieldError{
+		"upc",
+		fmt.Sprintf("does not match stored barcode of shipments: %v", mismatched),
+	}}
+}
+
+// barcodeFilter restricts the filter to shipments whose stored counterpart
+// of the Barcode or UPC in update is blank or matches it, so the values are not
+// mismatched by shipments modified after checkBarcodeUpdate. Stored Barcodes
+// are normalized to GTIN-14.
+func barcodeFilter(
+	filter map[string]interface{}, update map[string]interface{},
+) map[string]interface{} {
+	code, hasBarcode := update["barcode"].(string)
+	upc, upcErr := util.AssertInt64(update["upc"])
+	hasUPC := update["upc"] != nil && upcErr == nil
+	if hasBarcode == hasUPC {
+		return filter
+	}
+
+	var guard map[string]interface{}
+	if hasBarcode {
+		allowed := []interface{}{nil, int64(0)}
+		gtin, err := barcode.Normalize(code)
+		if err == nil {
+			gtinUPC, err := strconv.ParseInt(gtin, 10, 64)
+			if err == nil {
+				allowed = append(allowed, gtinUPC)
+			}
+		}
+		guard = map[string]interface{}{
+			"upc": map[string]interface{}{
+				"$in": allowed,
+			},
+		}
+	} else {
+		allowed := []interface{}{nil, ""}
+		gtin, err := barcode.FromInt(upc)
+		if err == nil {
+			allowed = append(allowed, gtin)
+		}
+		guard = map[string]interface{}{
+			"barcode": map[string]interface{}{
+				"$in": allowed,
+			},
+		}
+	}
+	return map[string]interface{}{
+		"$and": []interface{}{filter, guard},
+	}
+}
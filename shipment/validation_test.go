@@ -0,0 +1,97 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("barcode", func() {
+	It("should return error on insert if barcode check-digit is invalid", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		insertArgs := map[string]interface{}{
+			"itemID":  itemID.String(),
+			"barcode": "036000291453",
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should return error on insert if barcode and upc do not match", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		insertArgs := map[string]interface{}{
+			"itemID":  itemID.String(),
+			"barcode": "036000291452",
+			"upc":     123456789012,
+		}
+		mockEvent := newMockEvent("insert", insertArgs)
+		kr := Insert(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+		Expect(kr.Error).To(ContainSubstring("upc"))
+	})
+
+	It("should return error on update if upc check-digit is invalid", func() {
+		updateArgs := map[string]interface{}{
+			"filter": map[string]interface{}{
+				"lot": "A1",
+			},
+			"update": map[string]interface{}{
+				"upc": 123456789013,
+			},
+		}
+		mockEvent := newMockEvent("update", updateArgs)
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+	It("should check updates of barcode or upc against the stored counterpart", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		ships := []*Shipment{
+			&Shipment{ItemID: itemID, Barcode: "09501101530003", UPC: 9501101530003},
+			&Shipment{ItemID: otherID},
+		}
+
+		update := map[string]interface{}{
+			"upc": float64(9506000134352),
+		}
+		verrs := checkBarcodeUpdate(update, ships)
+		Expect(verrs).To(HaveLen(1))
+		Expect(verrs[0].Field).To(Equal("upc"))
+		Expect(verrs[0].Message).To(ContainSubstring(itemID.String()))
+		Expect(verrs[0].Message).ToNot(ContainSubstring(otherID.String()))
+		Expect(barcodeFilter(map[string]interface{}{}, update)).To(Equal(map[string]interface{}{
+			"$and": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{
+					"barcode": map[string]interface{}{
+						"$in": []interface{}{nil, "", "09506000134352"},
+					},
+				},
+			},
+		}))
+
+		update = map[string]interface{}{
+			"barcode": "09501101530003",
+		}
+		Expect(checkBarcodeUpdate(update, ships)).To(BeNil())
+		update["upc"] = float64(9506000134352)
+		Expect(checkBarcodeUpdate(update, ships)).To(BeNil())
+	})
+})
@@ -0,0 +1,82 @@
+package shipment
+
+import (
+	"reflect"
+
+	util "github.com/TerrexTech/go-commonutils/commonutil"
+
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+)
+
+// weightFields are the Shipment fields which contain weights.
+var weightFields = codecNames(weightField)
+
+// weightUnit returns the shipment's WeightUnit, which is the unit its weights
+// are received and rendered in. Weights are always stored in canonical unit.
+func (i *Shipment) weightUnit() weight.Unit {
+	unit, err := weight.ParseUnit(i.WeightUnit)
+	if err != nil {
+		return weight.Canonical
+	}
+	return unit
+}
+
+// convertWeights converts the shipment's weights between units.
+func (i *Shipment) convertWeights(from weight.Unit, to weight.Unit) {
+	fields := reflect.ValueOf(i).Elem()
+	for _, codec := range shipmentCodecs {
+		if codec.Kind == weightField && codec.Index >= 0 {
+			field := fields.Field(codec.Index)
+			field.SetFloat(weight.Convert(field.Float(), from, to))
+		}
+	}
+}
+
+// shipmentWeight is a weight in an update without WeightUnit, which is read
+// in the stored WeightUnit of each updated shipment, as done by storedUpdate.
+type shipmentWeight float64
+
+// validateUpdateWeights converts the weights in update to canonical unit,
+// from the update's WeightUnit. Weights updated without WeightUnit are read
+// in the WeightUnit of each updated shipment instead, as these are rendered.
+func validateUpdateWeights(update map[string]interface{}) ValidationErrors {
+	var unit weight.Unit
+	if update["weightUnit"] != nil {
+		symbol, assertOK := update["weightUnit"].(string)
+		if !assertOK {
+			return ValidationErrors{
+				FieldError{"weightUnit", "must be a string"},
+			}
+		}
+		var err error
+		unit, err = weight.ParseUnit(symbol)
+		if err != nil {
+			return ValidationErrors{
+				FieldError{"weightUnit", err.Error()},
+			}
+		}
+		update["weightUnit"] = string(unit)
+	}
+
+	verrs := ValidationErrors{}
+	for _, field := range weightFields {
+		if update[field] == nil {
+			continue
+		}
+		w, err := util.AssertFloat64(update[field])
+		if err != nil {
+			verrs = append(verrs, FieldError{field, "must be a number"})
+			continue
+		}
+		if unit == "" {
+			update[field] = shipmentWeight(w)
+			continue
+		}
+		update[field] = weight.Convert(w, unit, weight.Canonical)
+	}
+
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
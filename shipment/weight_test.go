@@ -0,0 +1,86 @@
+package shipment
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("weightUnit", func() {
+	It("should store weights in canonical unit", func() {
+		ship := &Shipment{}
+		err := json.Unmarshal([]byte(`{"totalWeight": 10, "weightUnit": "lb"}`), ship)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ship.TotalWeight).To(BeNumerically("~", 4.5359237))
+		Expect(ship.WeightUnit).To(Equal("lb"))
+	})
+
+	It("should render weights in shipment's unit", func() {
+		ship := &Shipment{
+			TotalWeight: 1,
+			WeightUnit:  "g",
+		}
+		marshalShip, err := json.Marshal(ship)
+		Expect(err).ToNot(HaveOccurred())
+
+		m := map[string]interface{}{}
+		err = json.Unmarshal(marshalShip, &m)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m["totalWeight"]).To(BeNumerically("~", 1000))
+	})
+})
+
+var _ = Describe("weightUnit in update", func() {
+	It("should return error if weightUnit is unsupported", func() {
+		updateArgs := map[string]interface{}{
+			"filter": map[string]interface{}{
+				"lot": "A1",
+			},
+			"update": map[string]interface{}{
+				"soldWeight": 3.2,
+				"weightUnit": "stone",
+			},
+		}
+		mockEvent := newMockEvent("update", updateArgs)
+		kr := Update(nil, mockEvent)
+		Expect(kr.AggregateID).To(Equal(mockEvent.AggregateID))
+		Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
+		Expect(kr.Error).ToNot(BeEmpty())
+		Expect(kr.ErrorCode).To(Equal(int16(ValidationError)))
+		Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
+	})
+
+	It("should read weights updated without weightUnit in the shipment's weightUnit", func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		stored := &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			TotalWeight: weight.Convert(10, weight.Pound, weight.Canonical),
+			WeightUnit:  "lb",
+		}
+		updates := []interface{}{}
+		collection := &fakeCollection{
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{stored}, nil
+			},
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				updates = append(updates, update)
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		}
+
+		kr := Update(collection, newMockEvent("update", []byte(`{"filter": {"lot": "A1"}, "update": {"totalWeight": 12}}`)))
+		Expect(kr.Error).To(BeEmpty())
+		Expect(updates).To(HaveLen(1))
+		Expect(updates[0]).To(HaveKeyWithValue(
+			"totalWeight", weight.Convert(12, weight.Pound, weight.Canonical),
+		))
+	})
+})
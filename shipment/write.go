@@ -0,0 +1,317 @@
+package shipment
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TerrexTech/agg-shipment-cmd/weight"
+	"github.com/pkg/errors"
+)
+
+// maxWriteAttempts is the number of times a shipment is read and written
+// again, when it is modified between being read and written.
+const maxWriteAttempts = 3
+
+// writeResult is the result of writing shipments using writeShipments.
+type writeResult struct {
+	// Changes are the written shipments, before and after the write.
+	Changes       []shipmentChange
+	ModifiedCount int64
+	// Failed are the shipments which were not written since these failed
+	// the preconditions.
+	Failed []*Shipment
+}
+
+// written returns the written shipments, as after the write.
+func (r *writeResult) written() []*Shipment {
+	ships := make([]*Shipment, len(r.Changes))
+	for i, change := range r.Changes {
+		ships[i] = change.After
+	}
+	return ships
+}
+
+// before returns the written shipments, as before the write.
+func (r *writeResult) before() []*Shipment {
+	ships := make([]*Shipment, len(r.Changes))
+	for i, change := range r.Changes {
+		ships[i] = change.Before
+	}
+	return ships
+}
+
+// withUpdate returns a copy of the shipment with the update applied, using
+// the update's values as stored in Mongo. Attributes are updated using
+// their paths, and attributes set to nil are removed.
+func (i *Shipment) withUpdate(update map[string]interface{}) (*Shipment, error) {
+	values := i.storedValues()
+	attributes := map[string]interface{}{}
+	for name, value := range i.Attributes {
+		attributes[name] = value
+	}
+	for field, value := range update {
+		if isAttributePath(field) {
+			name := strings.TrimPrefix(field, "attributes.")
+			if value == nil {
+				delete(attributes, name)
+			} else {
+				attributes[name] = value
+			}
+			continue
+		}
+		if field == "attributes" {
+			attributes, _ = value.(map[string]interface{})
+			continue
+		}
+		values[field] = value
+	}
+	values["attributes"] = attributes
+
+	updated := &Shipment{}
+	err := updated.unmarshalFromMap(values)
+	if err != nil {
+		err = errors.Wrapf(err, "Error applying update to shipment %s", i.ItemID)
+		return nil, err
+	}
+	return updated, nil
+}
+
+// storedUpdate returns the update as stored for the shipment, reading the
+// values which depend on the shipment, such as prices without currency and
+// weights without WeightUnit.
+func (i *Shipment) storedUpdate(
+	update map[string]interface{},
+) (map[string]interface{}, error) {
+	stored := make(map[string]interface{}, len(update))
+	verrs := ValidationErrors{}
+	for field, value := range update {
+		switch v := value.(type) {
+		case shipmentPrice:
+			m, err := assertMoney(v.value, i.storedCurrency())
+			if err != nil {
+				verrs = append(verrs, FieldError{field, err.Error()})
+				continue
+			}
+			stored[field] = m.Amount
+		case shipmentWeight:
+			stored[field] = weight.Convert(float64(v), i.weightUnit(), weight.Canonical)
+		default:
+			stored[field] = value
+		}
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+	return stored, nil
+}
+
+// checkStoredUpdate checks if the update can be read for each of the shipments,
+// such as prices without currency in the shipment's currency.
+func checkStoredUpdate(update map[string]interface{}, ships []*Shipment) ValidationErrors {
+	failed := map[string][]string{}
+	messages := map[string]string{}
+	for _, ship := range ships {
+		_, err := ship.storedUpdate(update)
+		verrs, isVerrs := err.(ValidationErrors)
+		if !isVerrs {
+			continue
+		}
+		for _, verr := range verrs {
+			failed[verr.Field] = append(failed[verr.Field], ship.ItemID.String())
+			messages[verr.Field] = verr.Message
+		}
+	}
+
+	verrs := ValidationErrors{}
+	for _, field := range sortedKeys(update) {
+		if len(failed[field]) == 0 {
+			continue
+		}
+		msg := messages[field]
+		if len(ships) > 1 {
+			msg = fmt.Sprintf("%s for shipments: %v", msg, failed[field])
+		}
+		verrs = append(verrs, FieldError{field, msg})
+	}
+	if len(verrs) == 0 {
+		return nil
+	}
+	return verrs
+}
+
+// writeShipments writes each of the shipments separately, which removes the
+// shipment if update is nil. Each write is restricted to the shipment if it
+// still matches the filter and preconditions, and the updated fields still
+// have the values they were read with, so the written changes are known.
+// Shipments modified after being read are read again, and are skipped if
+// these no longer match the filter, or reported as failed if these no longer
+// satisfy the preconditions.
+func writeShipments(
+	collection Collection,
+	filter map[string]interface{},
+	preconditions map[string]interface{},
+	ships []*Shipment,
+	update map[string]interface{},
+) (*writeResult, error) {
+	result := &writeResult{
+		Changes: []shipmentChange{},
+		Failed:  []*Shipment{},
+	}
+	for _, ship := range ships {
+		current := ship
+		for attempt := 1; ; attempt++ {
+			change, modified, err := writeShipment(
+				collection, filter, preconditions, current, update,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if change != nil {
+				result.Changes = append(result.Changes, *change)
+				if modified {
+					result.ModifiedCount++
+				}
+				break
+			}
+			if attempt == maxWriteAttempts {
+				err = errors.Errorf(
+					"shipment %s was modified concurrently in %d attempts to write it",
+					current.ItemID, attempt,
+				)
+				return nil, err
+			}
+
+			current, err = findCurrent(collection, filter, current)
+			if err != nil {
+				return nil, err
+			}
+			if current == nil {
+				break
+			}
+			if len(preconditions) > 0 {
+				satisfied, err := findCurrent(
+					collection, withPreconditions(filter, preconditions), current,
+				)
+				if err != nil {
+					return nil, err
+				}
+				if satisfied == nil {
+					result.Failed = append(result.Failed, current)
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// writeShipment writes the update to the shipment, if it still matches the
+// filter and preconditions, and its updated fields still have their current
+// values. The derived fields are written in the same update, so the fields
+// these are computed from must also still have their current values. The
+// change is nil if the shipment was not written.
+func writeShipment(
+	collection Collection,
+	filter map[string]interface{},
+	preconditions map[string]interface{},
+	current *Shipment,
+	update map[string]interface{},
+) (*shipmentChange, bool, error) {
+	guards := []interface{}{
+		filter,
+		map[string]interface{}{
+			"_id": current.ID,
+		},
+	}
+	if len(preconditions) > 0 {
+		guards = append(guards, preconditions)
+	}
+
+	if update == nil {
+		deleteStats, err := collection.DeleteMany(map[string]interface{}{
+			"$and": guards,
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "Error removing shipment %s", current.ItemID)
+			return nil, false, err
+		}
+		if deleteStats.DeletedCount == 0 {
+			return nil, false, nil
+		}
+		return &shipmentChange{Before: current}, true, nil
+	}
+
+	update, err := current.storedUpdate(update)
+	if err != nil {
+		err = errors.Wrapf(err, "Error reading update for shipment %s", current.ItemID)
+		return nil, false, err
+	}
+	after, err := current.withUpdate(update)
+	if err != nil {
+		return nil, false, err
+	}
+	guards = append(guards, currentValues(current, update), derivedFilter(current))
+	updateStats, err := collection.UpdateMany(
+		map[string]interface{}{
+			"$and": guards,
+		},
+		withDerived(update, after),
+	)
+	if err != nil {
+		err = errors.Wrapf(err, "Error writing shipment %s", current.ItemID)
+		return nil, false, err
+	}
+	if updateStats.MatchedCount == 0 {
+		return nil, false, nil
+	}
+	change := &shipmentChange{
+		Before: current,
+		After:  after,
+	}
+	return change, updateStats.ModifiedCount > 0, nil
+}
+
+// currentValues is the filter matching the current values of the fields in
+// update. Attributes are matched using their paths, since Mongo matches whole
+// documents only if their keys are in the same order.
+func currentValues(current *Shipment, update map[string]interface{}) map[string]interface{} {
+	stored := current.storedValues()
+	filter := map[string]interface{}{}
+	for field := range update {
+		switch {
+		case isAttributePath(field):
+			// Nil also matches missing attributes
+			name := strings.TrimPrefix(field, "attributes.")
+			filter[field] = current.Attributes[name]
+		case field == "attributes":
+			for name, value := range current.Attributes {
+				filter["attributes."+name] = value
+			}
+		default:
+			filter[field] = zeroMatch(stored[field])
+		}
+	}
+	return filter
+}
+
+// findCurrent finds the shipment again, if it still matches the filter.
+func findCurrent(
+	collection Collection, filter map[string]interface{}, ship *Shipment,
+) (*Shipment, error) {
+	found, err := findShipments(collection, map[string]interface{}{
+		"$and": []interface{}{
+			filter,
+			map[string]interface{}{
+				"_id": ship.ID,
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "Error finding shipment %s again", ship.ItemID)
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return found[0], nil
+}
@@ -0,0 +1,163 @@
+package shipment
+
+import (
+	"github.com/TerrexTech/uuuid"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("writeShipments", func() {
+	var (
+		held    *Shipment
+		written *Shipment
+	)
+
+	BeforeEach(func() {
+		itemID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		written = &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			TotalWeight: 10,
+		}
+		itemID, err = uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		held = &Shipment{
+			ID:          objectid.New(),
+			ItemID:      itemID,
+			Lot:         "A1",
+			TotalWeight: 10,
+		}
+	})
+
+	It("should apply updates using the stored values", func() {
+		ship := &Shipment{
+			ItemID:     written.ItemID,
+			Attributes: map[string]interface{}{"organic": true, "poLine": 4.0},
+			DeletedAt:  1540000000,
+			WeightUnit: "lb",
+		}
+		after, err := ship.withUpdate(map[string]interface{}{
+			"attributes.organic": nil,
+			"attributes.grade":   "A",
+			"deletedAt":          0,
+			"soldWeight":         2.5,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(after.Attributes).To(Equal(map[string]interface{}{
+			"grade":  "A",
+			"poLine": 4.0,
+		}))
+		Expect(after.DeletedAt).To(BeZero())
+		Expect(after.SoldWeight).To(Equal(2.5))
+		Expect(after.WeightUnit).To(Equal("lb"))
+		Expect(ship.Attributes).To(HaveLen(2))
+	})
+
+	It("should report only the unwritten shipments failing the preconditions", func() {
+		// The second shipment is held after the preconditions were checked
+		current := *held
+		current.Lot = "B2"
+		writes := 0
+		collection := &fakeCollection{
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				writes++
+				if writes == 1 {
+					return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+				}
+				return &mgo.UpdateResult{}, nil
+			},
+			find: func(filter interface{}) ([]interface{}, error) {
+				matched := filter.(map[string]interface{})["$and"].([]interface{})[0]
+				// Found again by the filter, but not along with the preconditions
+				_, withPreconditions := matched.(map[string]interface{})["$and"]
+				if withPreconditions {
+					return []interface{}{}, nil
+				}
+				return []interface{}{&current}, nil
+			},
+		}
+
+		result, err := writeShipments(
+			collection,
+			map[string]interface{}{"lot": "A1"},
+			map[string]interface{}{"lot": "A1"},
+			[]*Shipment{written, held},
+			map[string]interface{}{"soldWeight": 2.5},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Changes).To(HaveLen(1))
+		Expect(result.Changes[0].Before).To(Equal(written))
+		Expect(result.Changes[0].After.SoldWeight).To(Equal(2.5))
+		Expect(result.ModifiedCount).To(Equal(int64(1)))
+		Expect(result.Failed).To(Equal([]*Shipment{&current}))
+	})
+
+	It("should write the derived fields along with the update", func() {
+		var (
+			writtenFilter interface{}
+			writtenUpdate interface{}
+		)
+		collection := &fakeCollection{
+			updateMany: func(filter interface{}, update interface{}) (*mgo.UpdateResult, error) {
+				writtenFilter = filter
+				writtenUpdate = update
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		}
+		update := map[string]interface{}{"soldWeight": 2.5}
+		result, err := writeShipments(
+			collection, map[string]interface{}{}, nil, []*Shipment{written}, update,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Changes).To(HaveLen(1))
+		Expect(update).To(HaveLen(1))
+		Expect(writtenUpdate).To(Equal(map[string]interface{}{
+			"soldWeight":      2.5,
+			"daysUntilExpiry": int64(0),
+			"margin":          int64(0),
+			"remainingWeight": 7.5,
+			"sellThrough":     25.0,
+		}))
+		// The derived fields are only written if their sources are unchanged
+		guards := writtenFilter.(map[string]interface{})["$and"].([]interface{})
+		Expect(guards).To(ContainElement(derivedFilter(written)))
+		Expect(derivedFilter(written)).To(HaveKeyWithValue("totalWeight", 10.0))
+	})
+
+	It("should skip shipments which no longer match the filter", func() {
+		collection := &fakeCollection{
+			deleteMany: func(filter interface{}) (*mgo.DeleteResult, error) {
+				return &mgo.DeleteResult{}, nil
+			},
+			find: func(filter interface{}) ([]interface{}, error) {
+				return []interface{}{}, nil
+			},
+		}
+		result, err := writeShipments(
+			collection, map[string]interface{}{"lot": "A1"}, nil, []*Shipment{written}, nil,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Changes).To(BeEmpty())
+		Expect(result.Failed).To(BeEmpty())
+	})
+
+	It("should match the current values of the updated fields", func() {
+		ship := &Shipment{
+			Attributes: map[string]interface{}{"organic": true},
+			Lot:        "A1",
+		}
+		Expect(currentValues(ship, map[string]interface{}{
+			"attributes.poLine": 4,
+			"lot":               "B2",
+			"origin":            "farm",
+		})).To(Equal(map[string]interface{}{
+			"attributes.poLine": nil,
+			"lot":               "A1",
+			"origin":            map[string]interface{}{"$in": []interface{}{nil, ""}},
+		}))
+	})
+})
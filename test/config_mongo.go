@@ -3,8 +3,7 @@ package test
 import (
 	"os"
 
-	"github.com/TerrexTech/agg-metrics-cmd/metric"
-
+	"github.com/TerrexTech/agg-shipment-cmd/shipment"
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 )
@@ -13,7 +12,14 @@ func loadAggCollection() (*mongo.Collection, error) {
 	database := os.Getenv("MONGO_DATABASE")
 	aggCollection := os.Getenv("MONGO_AGG_COLLECTION")
 
-	return loadMongoCollection(database, aggCollection, &metric.Metric{})
+	return loadMongoCollection(database, aggCollection, &shipment.Shipment{})
+}
+
+func loadOutboxCollection() (*mongo.Collection, error) {
+	database := os.Getenv("MONGO_DATABASE")
+	outboxCollection := os.Getenv("MONGO_OUTBOX_COLLECTION")
+
+	return loadMongoCollection(database, outboxCollection, &shipment.OutboxEntry{})
 }
 
 func loadMongoCollection(
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-shipment-cmd/money"
 	"github.com/TerrexTech/agg-shipment-cmd/shipment"
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-eventstore-models/model"
@@ -47,11 +48,13 @@ func TestInventory(t *testing.T) {
 		"KAFKA_PRODUCER_EVENT_TOPIC",
 		"KAFKA_PRODUCER_EVENT_QUERY_TOPIC",
 		"KAFKA_PRODUCER_RESPONSE_TOPIC",
+		"KAFKA_PRODUCER_DOMAIN_EVENT_TOPIC",
 
 		"MONGO_HOSTS",
 		"MONGO_USERNAME",
 		"MONGO_PASSWORD",
 		"MONGO_DATABASE",
+		"MONGO_OUTBOX_COLLECTION",
 		"MONGO_CONNECTION_TIMEOUT_MS",
 		"MONGO_RESOURCE_TIMEOUT_MS",
 	)
@@ -71,6 +74,7 @@ var _ = Describe("ShipmentAggregate", func() {
 		kafkaBrokers          []string
 		eventsTopic           string
 		producerResponseTopic string
+		domainEventTopic      string
 
 		mockShip  *shipment.Shipment
 		mockEvent *model.Event
@@ -81,6 +85,7 @@ var _ = Describe("ShipmentAggregate", func() {
 		)
 		eventsTopic = os.Getenv("KAFKA_PRODUCER_EVENT_TOPIC")
 		producerResponseTopic = os.Getenv("KAFKA_PRODUCER_RESPONSE_TOPIC")
+		domainEventTopic = os.Getenv("KAFKA_PRODUCER_DOMAIN_EVENT_TOPIC")
 
 		itemID, err := uuuid.NewV4()
 		Expect(err).ToNot(HaveOccurred())
@@ -96,10 +101,10 @@ var _ = Describe("ShipmentAggregate", func() {
 			Lot:          "test-lot",
 			Name:         "test-name",
 			Origin:       "test-origin",
-			Price:        13.4,
+			Price:        money.FromMinor(1340, "USD"),
 			Quantity:     45,
 			RSCustomerID: rsCustomerID,
-			SalePrice:    12.23,
+			SalePrice:    money.FromMinor(1223, "USD"),
 			SKU:          "test-sku",
 			Timestamp:    time.Now().Unix(),
 			TotalWeight:  300,
@@ -313,12 +318,13 @@ var _ = Describe("ShipmentAggregate", func() {
 					Expect(kr.CorrelationID).To(Equal(mockEvent.CorrelationID))
 					Expect(kr.UUID).To(Equal(mockEvent.TimeUUID))
 
-					result := map[string]int{}
+					result := map[string]interface{}{}
 					err = json.Unmarshal(kr.Result, &result)
 					Expect(err).ToNot(HaveOccurred())
 
-					if result["deletedCount"] != 0 {
-						Expect(result["deletedCount"]).To(Equal(1))
+					if result["deletedCount"] != nil {
+						Expect(result["deletedCount"]).To(Equal(float64(1)))
+						Expect(result["shipments"]).To(HaveLen(1))
 						return true
 					}
 				}
@@ -328,11 +334,155 @@ var _ = Describe("ShipmentAggregate", func() {
 			handler := &msgHandler{msgCallback}
 			c.Consume(context.Background(), handler)
 
-			Byf("Checking if record got inserted into Database")
+			Byf("Checking if record got soft-deleted in Database")
 			aggColl, err := loadAggCollection()
 			Expect(err).ToNot(HaveOccurred())
-			_, err = aggColl.FindOne(mockShip)
-			Expect(err).To(HaveOccurred())
+			findResult, err := aggColl.FindOne(map[string]interface{}{
+				"itemID": mockShip.ItemID.String(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			findShip, assertOK := findResult.(*shipment.Shipment)
+			Expect(assertOK).To(BeTrue())
+			Expect(findShip.DeletedAt).ToNot(BeZero())
+			Expect(findShip.DeletedBy).To(Equal(mockEvent.UserUUID))
+
+			close(done)
+		}, 20)
+	})
+
+	Describe("Outbox", func() {
+		// insertEvent creates the Event inserting a new shipment, and
+		// produces it.
+		insertEvent := func() (*shipment.Shipment, *model.Event) {
+			itemID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			ship := &shipment.Shipment{
+				ItemID:      itemID,
+				DateArrived: time.Now().Unix(),
+				Lot:         "test-lot",
+				Origin:      "test-origin",
+				Timestamp:   time.Now().Unix(),
+				TotalWeight: 300,
+			}
+			marshalShip, err := json.Marshal(ship)
+			Expect(err).ToNot(HaveOccurred())
+
+			cid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			timeUUID, err := uuuid.NewV1()
+			Expect(err).ToNot(HaveOccurred())
+			event := &model.Event{
+				Action:        "insert",
+				CorrelationID: cid,
+				AggregateID:   aggregateID,
+				Data:          marshalShip,
+				Timestamp:     time.Now(),
+				UserUUID:      uid,
+				TimeUUID:      timeUUID,
+				Version:       0,
+				YearBucket:    2018,
+			}
+
+			Byf("Producing MockEvent")
+			p, err := kafka.NewProducer(&kafka.ProducerConfig{
+				KafkaBrokers: kafkaBrokers,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			marshalEvent, err := json.Marshal(event)
+			Expect(err).ToNot(HaveOccurred())
+			p.Input() <- kafka.CreateMessage(eventsTopic, marshalEvent)
+			return ship, event
+		}
+
+		It("should relay the DomainEvents of inserted records", func(done Done) {
+			ship, event := insertEvent()
+
+			Byf("Consuming DomainEvents")
+			c, err := kafka.NewConsumer(&kafka.ConsumerConfig{
+				KafkaBrokers: kafkaBrokers,
+				GroupName:    "aggship.test.events.1",
+				Topics:       []string{domainEventTopic},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			msgCallback := func(msg *sarama.ConsumerMessage) bool {
+				defer GinkgoRecover()
+				domainEvent := &shipment.DomainEvent{}
+				err := json.Unmarshal(msg.Value, domainEvent)
+				Expect(err).ToNot(HaveOccurred())
+
+				if domainEvent.CausationID == event.TimeUUID {
+					Expect(domainEvent.Type).To(Equal(shipment.ShipmentCreated))
+					Expect(domainEvent.AggregateID).To(Equal(aggregateID))
+					Expect(domainEvent.ItemID).To(Equal(ship.ItemID))
+					Expect(domainEvent.CorrelationID).To(Equal(event.CorrelationID))
+					Expect(domainEvent.Before).To(BeNil())
+					Expect(domainEvent.After.ItemID).To(Equal(ship.ItemID))
+					Expect(domainEvent.After.Lot).To(Equal(ship.Lot))
+					return true
+				}
+				return false
+			}
+
+			handler := &msgHandler{msgCallback}
+			c.Consume(context.Background(), handler)
+
+			close(done)
+		}, 20)
+
+		It("should relay responses, and mark their entries as sent", func(done Done) {
+			ship, event := insertEvent()
+
+			Byf("Consuming Result")
+			c, err := kafka.NewConsumer(&kafka.ConsumerConfig{
+				KafkaBrokers: kafkaBrokers,
+				GroupName:    "aggship.test.group.1",
+				Topics:       []string{producerResponseTopic},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			msgCallback := func(msg *sarama.ConsumerMessage) bool {
+				defer GinkgoRecover()
+				kr := &model.KafkaResponse{}
+				err := json.Unmarshal(msg.Value, kr)
+				Expect(err).ToNot(HaveOccurred())
+
+				if kr.UUID == event.TimeUUID {
+					Expect(kr.Error).To(BeEmpty())
+					Expect(kr.CorrelationID).To(Equal(event.CorrelationID))
+
+					insertedShip := &shipment.Shipment{}
+					err = json.Unmarshal(kr.Result, insertedShip)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(insertedShip.ItemID).To(Equal(ship.ItemID))
+					return true
+				}
+				return false
+			}
+
+			handler := &msgHandler{msgCallback}
+			c.Consume(context.Background(), handler)
+
+			Byf("Checking if outbox entry got marked as sent")
+			outboxColl, err := loadOutboxCollection()
+			Expect(err).ToNot(HaveOccurred())
+			// The entry is marked once Kafka acknowledged its messages,
+			// which can be after the response was consumed
+			Eventually(func() ([]interface{}, error) {
+				return outboxColl.Find(map[string]interface{}{
+					"correlationID": event.CorrelationID.String(),
+					"state":         "sent",
+				})
+			}, 5).Should(HaveLen(1))
+
+			findResults, err := outboxColl.Find(map[string]interface{}{
+				"correlationID": event.CorrelationID.String(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(findResults).To(HaveLen(1))
+			entry, assertOK := findResults[0].(*shipment.OutboxEntry)
+			Expect(assertOK).To(BeTrue())
+			Expect(entry.ID).To(Equal(event.TimeUUID.String()))
 
 			close(done)
 		}, 20)
@@ -0,0 +1,54 @@
+// Package weight provides conversions between units of weight.
+package weight
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Unit is a unit of weight.
+type Unit string
+
+// Supported Units.
+const (
+	Gram     Unit = "g"
+	Kilogram Unit = "kg"
+	Ounce    Unit = "oz"
+	Pound    Unit = "lb"
+)
+
+// Canonical is the Unit in which weights are stored.
+const Canonical = Kilogram
+
+// grams are the number of grams in each Unit.
+var grams = map[Unit]float64{
+	Gram:     1,
+	Kilogram: 1000,
+	Ounce:    28.349523125,
+	Pound:    453.59237,
+}
+
+// ParseUnit parses the Unit from its symbol. A blank symbol
+// is read as the Canonical Unit.
+func ParseUnit(symbol string) (Unit, error) {
+	symbol = strings.ToLower(strings.TrimSpace(symbol))
+	if symbol == "" {
+		return Canonical, nil
+	}
+	unit := Unit(symbol)
+	if _, exists := grams[unit]; !exists {
+		return "", errors.Errorf(
+			"unsupported weight unit: %s, must be g, kg, oz or lb", symbol,
+		)
+	}
+	return unit, nil
+}
+
+// Convert converts the weight between Units. Both Units must be supported.
+func Convert(value float64, from Unit, to Unit) float64 {
+	if from == to {
+		return value
+	}
+	return value * grams[from] / grams[to]
+}
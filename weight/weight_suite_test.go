@@ -0,0 +1,44 @@
+package weight
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestWeight(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Weight Suite")
+}
+
+var _ = Describe("Weight", func() {
+	Describe("ParseUnit", func() {
+		It("should parse supported units", func() {
+			for _, symbol := range []string{"g", "kg", "oz", "lb"} {
+				unit, err := ParseUnit(symbol)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(unit).To(Equal(Unit(symbol)))
+			}
+		})
+
+		It("should read blank unit as canonical unit", func() {
+			unit, err := ParseUnit("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unit).To(Equal(Canonical))
+		})
+
+		It("should return error if unit is unsupported", func() {
+			_, err := ParseUnit("stone")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Convert", func() {
+		It("should convert weights between units", func() {
+			Expect(Convert(1, Pound, Kilogram)).To(BeNumerically("~", 0.45359237))
+			Expect(Convert(16, Ounce, Pound)).To(BeNumerically("~", 1))
+			Expect(Convert(2.5, Kilogram, Gram)).To(BeNumerically("~", 2500))
+		})
+	})
+})